@@ -5,14 +5,19 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/vearutop/plt/fasthttp"
 	"github.com/vearutop/plt/loadgen"
 	"github.com/vearutop/plt/nethttp"
+	"github.com/vearutop/plt/report"
 )
 
 // AddCommand registers curl command into CLI app.
@@ -28,6 +33,27 @@ func AddCommand(lf *loadgen.Flags, options ...func(lf *loadgen.Flags, f *nethttp
 			user       string
 			output     string
 			head       bool
+
+			proxyUser      string
+			socks5         string
+			socks5Hostname string
+
+			abstractUnixSocket string
+			sourceIPs          string
+			limitRate          string
+
+			connectTimeout   string
+			maxTime          string
+			expect100Timeout string
+
+			retry         string
+			retryDelay    string
+			retryMaxTime  string
+			retryOnStatus string
+
+			digest       bool
+			anyAuth      bool
+			oauth2Bearer string
 		}
 		captureStrings = map[string]*[]string{
 			"header":         &capture.header,
@@ -42,12 +68,41 @@ func AddCommand(lf *loadgen.Flags, options ...func(lf *loadgen.Flags, f *nethttp
 			"request": &flags.Method,
 			"user":    &capture.user,
 			"output":  &capture.output,
+
+			"proxy":           &flags.Proxy,
+			"proxy-user":      &capture.proxyUser,
+			"socks5":          &capture.socks5,
+			"socks5-hostname": &capture.socks5Hostname,
+
+			"unix-socket":          &flags.UnixSocket,
+			"abstract-unix-socket": &capture.abstractUnixSocket,
+
+			"interface":  &flags.Interface,
+			"local-port": &flags.LocalPort,
+			"limit-rate": &capture.limitRate,
+
+			"connect-timeout":   &capture.connectTimeout,
+			"max-time":          &capture.maxTime,
+			"expect100-timeout": &capture.expect100Timeout,
+
+			"retry":          &capture.retry,
+			"retry-delay":    &capture.retryDelay,
+			"retry-max-time": &capture.retryMaxTime,
+
+			"oauth2-bearer": &capture.oauth2Bearer,
 		}
 		captureBool = map[string]*bool{
 			"compressed":   &capture.compressed,
 			"no-keepalive": &flags.NoKeepalive,
 			"http2":        &flags.HTTP2,
 			"head":         &capture.head,
+
+			"retry-connrefused": &flags.RetryConnRefused,
+
+			"digest":  &capture.digest,
+			"anyauth": &capture.anyAuth,
+
+			"insecure": &flags.Insecure,
 		}
 		ignoredString = map[string]*string{}
 		ignoredBool   = map[string]*bool{}
@@ -59,8 +114,69 @@ func AddCommand(lf *loadgen.Flags, options ...func(lf *loadgen.Flags, f *nethttp
 
 	if nethttp.HTTP3Available {
 		curl.Flag("http3", "Use quic-go http3").BoolVar(&flags.HTTP3)
+		curl.Flag("quic-0rtt", "Resume TLS sessions of new QUIC connections and send GET and HEAD requests in 0-RTT (use with --http3).").
+			BoolVar(&flags.QUIC0RTT)
+		curl.Flag("quic-idle-timeout", "Maximum duration of QUIC connection inactivity (use with --http3).").
+			PlaceHolder("30s").DurationVar(&flags.QUICIdleTimeout)
+		curl.Flag("quic-stream-window", "Initial stream-level flow control window in bytes (use with --http3).").
+			PlaceHolder("BYTES").Uint64Var(&flags.QUICStreamWindow)
+		curl.Flag("quic-conn-window", "Initial connection-level flow control window in bytes (use with --http3).").
+			PlaceHolder("BYTES").Uint64Var(&flags.QUICConnWindow)
 	}
 
+	curl.Flag("source-ips", "Comma-separated list of local IPs to rotate for outgoing connections.").
+		PlaceHolder("10.0.0.1,10.0.0.2").StringVar(&capture.sourceIPs)
+
+	curl.Flag("retry-on-status", "Comma-separated list of HTTP statuses to retry (use with --retry), "+
+		"default 408,429,500,502,503,504.").PlaceHolder("502,503").StringVar(&capture.retryOnStatus)
+	curl.Flag("retry-backoff", "Base of exponential backoff with jitter between retries if --retry-delay is not set.").
+		Default("1s").DurationVar(&flags.RetryBackoff)
+
+	curl.Flag("h2-conns", "Number of HTTP/2 connections to round-robin streams across (use with --http2), "+
+		"0 opens connections on demand.").PlaceHolder("N").IntVar(&flags.H2Conns)
+
+	curl.Flag("pipeline", "Number of pipelined HTTP/1.1 requests per connection, implies --fast.").
+		PlaceHolder("N").IntVar(&flags.Pipeline)
+	curl.Flag("pipeline-conns", "Number of connections for --pipeline, default is enough to serve concurrency.").
+		PlaceHolder("N").IntVar(&flags.PipelineConns)
+
+	curl.Flag("collect-header", "Response header to count distinct values of, can be repeated.").
+		PlaceHolder("X-Cache").StringsVar(&flags.CollectHeader)
+	curl.Flag("collect-header-latency", "Numeric response header to build latency distribution of, unit is ms (default), s or us, can be repeated.").
+		PlaceHolder("X-Upstream-Time:ms").StringsVar(&flags.CollectHeaderLatency)
+
+	curl.Flag("sample-dir", "Directory to save request and response samples to, including failed and slowest requests.").
+		PlaceHolder("DIR").StringVar(&flags.SampleDir)
+	curl.Flag("sample-count", "Number of samples per status code to save to --sample-dir.").
+		Default("1").IntVar(&flags.SampleCount)
+	curl.Flag("sample-size", "Maximum number of response body bytes to keep in a sample.").
+		Default("1000").IntVar(&flags.SampleSize)
+
+	curl.Flag("slowest", "Number of slowest requests to report with phase timings, status and trace IDs.").
+		PlaceHolder("N").IntVar(&flags.SlowestCount)
+	curl.Flag("slowest-json", "File to export slowest requests with response headers to, implies --slowest=10 if not set.").
+		PlaceHolder("FILE").StringVar(&flags.SlowestJSON)
+
+	curl.Flag("traceparent", "Inject W3C traceparent header with trace ID derived from run ID and request index.").
+		BoolVar(&flags.TraceParent)
+	curl.Flag("request-id", "Inject X-Request-Id header derived from run ID and request index.").BoolVar(&flags.RequestID)
+	curl.Flag("run-id", "Run ID for --traceparent and --request-id, random by default.").StringVar(&flags.RunID)
+
+	curl.Flag("otlp-endpoint", "OTLP/HTTP endpoint to export request spans to, implies --traceparent.").
+		PlaceHolder("http://localhost:4318").StringVar(&flags.OTLPEndpoint)
+	curl.Flag("otlp-sample-ratio", "Ratio of requests to export spans of, greater than 0 and up to 1.").
+		Default("1").Float64Var(&flags.OTLPSampleRatio)
+	curl.Flag("otlp-service-name", "Service name of exported spans.").Default("plt").StringVar(&flags.OTLPServiceName)
+
+	curl.Flag("stream", "Read response as a stream of events to measure time to first event and inter-event latency, "+
+		"sse for Server-Sent Events, lines for newline-delimited messages, chunks for raw reads.").
+		EnumVar(&flags.Stream, nethttp.StreamSSE, nethttp.StreamLines, nethttp.StreamChunks)
+
+	curl.Flag("tls-resume", "Share TLS session cache between connections to resume sessions.").BoolVar(&flags.TLSResume)
+
+	curl.Flag("aws-sigv4", "Use AWS V4 signature authentication with --user as access and secret keys.").
+		PlaceHolder("PROVIDER1[:PROVIDER2[:REGION[:SERVICE]]]").StringVar(&flags.AWSSigV4)
+
 	curl.Flag("2.0", `Workaround of Firefox "Copy as cURL" incompatibility.`).Bool()
 	curl.Arg("url", "The URL.").StringVar(&flags.URL)
 
@@ -153,7 +269,17 @@ func AddCommand(lf *loadgen.Flags, options ...func(lf *loadgen.Flags, f *nethttp
 				return errors.New("user parameter must be in form user:pass")
 			}
 
-			flags.HeaderMap["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(capture.user))
+			if capture.digest || capture.anyAuth || flags.AWSSigV4 != "" {
+				flags.User = capture.user
+				flags.AuthDigest = capture.digest
+				flags.AuthAny = capture.anyAuth
+			} else {
+				flags.HeaderMap["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(capture.user))
+			}
+		}
+
+		if capture.oauth2Bearer != "" {
+			flags.HeaderMap["Authorization"] = "Bearer " + capture.oauth2Bearer
 		}
 
 		if capture.head {
@@ -179,8 +305,64 @@ func AddCommand(lf *loadgen.Flags, options ...func(lf *loadgen.Flags, f *nethttp
 
 		if capture.compressed {
 			if _, ok := flags.HeaderMap["Accept-Encoding"]; !ok {
-				flags.HeaderMap["Accept-Encoding"] = "gzip, deflate"
+				flags.HeaderMap["Accept-Encoding"] = "gzip, deflate, br, zstd"
+			}
+		}
+
+		for _, t := range []struct {
+			s string
+			d *time.Duration
+		}{
+			{s: capture.connectTimeout, d: &flags.ConnectTimeout},
+			{s: capture.maxTime, d: &flags.MaxTime},
+			{s: capture.expect100Timeout, d: &flags.Expect100Timeout},
+			{s: capture.retryDelay, d: &flags.RetryDelay},
+			{s: capture.retryMaxTime, d: &flags.RetryMaxTime},
+		} {
+			if t.s == "" {
+				continue
 			}
+
+			sec, err := strconv.ParseFloat(t.s, 64)
+			if err != nil {
+				return fmt.Errorf("invalid timeout %q: %w", t.s, err)
+			}
+
+			*t.d = time.Duration(sec * float64(time.Second))
+		}
+
+		if err := prepareRetry(&flags, capture.retry, capture.retryOnStatus); err != nil {
+			return err
+		}
+
+		if capture.limitRate != "" {
+			r, err := parseRate(capture.limitRate)
+			if err != nil {
+				return err
+			}
+
+			flags.LimitRate = r
+		}
+
+		if flags.H2Conns > 0 {
+			flags.HTTP2 = true
+		}
+
+		if flags.Pipeline > 0 {
+			flags.Fast = true
+		}
+
+		if capture.sourceIPs != "" {
+			flags.SourceIPs = strings.Split(capture.sourceIPs, ",")
+		}
+
+		if capture.abstractUnixSocket != "" {
+			// Leading @ denotes abstract socket namespace in Go.
+			flags.UnixSocket = "@" + capture.abstractUnixSocket
+		}
+
+		if err := prepareProxy(&flags, capture.socks5, capture.socks5Hostname, capture.proxyUser); err != nil {
+			return err
 		}
 
 		if flags.NoKeepalive && (capture.output == "/dev/null" || capture.output == "nul") {
@@ -196,6 +378,89 @@ func AddCommand(lf *loadgen.Flags, options ...func(lf *loadgen.Flags, f *nethttp
 	})
 }
 
+func prepareRetry(flags *nethttp.Flags, retry, retryOnStatus string) error {
+	if retry != "" {
+		n, err := strconv.Atoi(retry)
+		if err != nil {
+			return fmt.Errorf("invalid retry: %w", err)
+		}
+
+		flags.Retry = n
+	}
+
+	if retryOnStatus == "" {
+		return nil
+	}
+
+	for _, s := range strings.Split(retryOnStatus, ",") {
+		code, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return fmt.Errorf("invalid retry status %q: %w", s, err)
+		}
+
+		flags.RetryOnStatus = append(flags.RetryOnStatus, code)
+	}
+
+	return nil
+}
+
+// parseRate parses curl-style transfer speed, e.g. 200K, 3m or 1G.
+func parseRate(s string) (int64, error) {
+	mul := int64(1)
+
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		mul = report.KILOBYTE
+	case "M":
+		mul = report.MEGABYTE
+	case "G":
+		mul = report.GIGABYTE
+	}
+
+	if mul != 1 {
+		s = s[:len(s)-1]
+	}
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("invalid limit rate: %q", s)
+	}
+
+	return int64(v * float64(mul)), nil
+}
+
+func prepareProxy(flags *nethttp.Flags, socks5, socks5Hostname, proxyUser string) error {
+	switch {
+	case socks5 != "":
+		flags.Proxy = "socks5://" + socks5
+	case socks5Hostname != "":
+		flags.Proxy = "socks5h://" + socks5Hostname
+	}
+
+	if proxyUser == "" {
+		return nil
+	}
+
+	if flags.Proxy == "" {
+		return errors.New("proxy-user parameter requires proxy")
+	}
+
+	if !strings.Contains(flags.Proxy, "://") {
+		flags.Proxy = "http://" + flags.Proxy
+	}
+
+	u, err := url.Parse(flags.Proxy)
+	if err != nil {
+		return fmt.Errorf("failed to parse proxy URL: %w", err)
+	}
+
+	user, pass, _ := strings.Cut(proxyUser, ":")
+	u.User = url.UserPassword(user, pass)
+	flags.Proxy = u.String()
+
+	return nil
+}
+
 func run(lf loadgen.Flags, f nethttp.Flags, options ...func(lf *loadgen.Flags, f *nethttp.Flags, j loadgen.JobProducer)) error {
 	lf.Prepare()
 
@@ -214,5 +479,11 @@ func run(lf loadgen.Flags, f nethttp.Flags, options ...func(lf *loadgen.Flags, f
 		}
 	}
 
-	return loadgen.Run(lf, j)
+	err = loadgen.Run(lf, j)
+
+	if c, ok := j.(io.Closer); ok {
+		err = errors.Join(err, c.Close())
+	}
+
+	return err
 }
@@ -2,9 +2,16 @@
 package fasthttp
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
+	"net/http/httptrace"
 	"net/url"
+	"os"
 	"strconv"
 	"strings"
 	"sync"
@@ -12,6 +19,7 @@ import (
 	"time"
 
 	"github.com/valyala/fasthttp"
+	"github.com/vearutop/dynhist-go"
 	"github.com/vearutop/plt/loadgen"
 	"github.com/vearutop/plt/nethttp"
 	"github.com/vearutop/plt/report"
@@ -24,14 +32,39 @@ type JobProducer struct {
 	bytesWritten int64
 	bytesRead    int64
 
-	mu       sync.Mutex
-	respCode map[int]int
-	respBody map[int][]byte
+	dnsHist   *dynhist.Collector
+	connHist  *dynhist.Collector
+	tlsHist   *dynhist.Collector
+	ttfbHist  *dynhist.Collector
+	proxyHist *dynhist.Collector
+
+	upstreamHist        *dynhist.Collector
+	upstreamHistPrecise *dynhist.Collector
+
+	mu         sync.Mutex
+	respCode   map[int]int
+	respBody   map[int][]byte
+	respHeader map[int][]byte
 
 	body   []byte
 	f      nethttp.Flags
 	client *fasthttp.Client
-
+	do     doer
+	dialer *nethttp.Dialer
+	dns    resolver
+
+	pipeline *pipeline
+	tls      bool
+
+	compression nethttp.CompressionStats
+	sampler     *nethttp.Sampler
+	slowest     *nethttp.Slowest
+	traces      *nethttp.TraceContext
+	otlp        *nethttp.OTLPExporter
+	sampleSize  int
+	headers     *nethttp.HeaderStats
+
+	out io.Writer
 	log string
 }
 
@@ -73,6 +106,75 @@ func (c countingConn) Write(b []byte) (n int, err error) {
 	return n, err
 }
 
+// timedConn measures time to first response byte.
+type timedConn struct {
+	net.Conn
+
+	j        *JobProducer
+	mu       sync.Mutex
+	reqStart time.Time
+	reading  bool
+}
+
+// Read reads data from the connection.
+// Read can be made to time out and return an Error with Timeout() == true
+// after a fixed time limit; see SetDeadline and SetReadDeadline.
+func (c *timedConn) Read(b []byte) (n int, err error) {
+	n, err = c.Conn.Read(b)
+
+	if n > 0 {
+		c.mu.Lock()
+		if !c.reading {
+			c.reading = true
+			c.j.ttfbHist.Add(1000 * time.Since(c.reqStart).Seconds())
+		}
+		c.mu.Unlock()
+	}
+
+	return n, err
+}
+
+// Write writes data to the connection.
+// Write can be made to time out and return an Error with Timeout() == true
+// after a fixed time limit; see SetDeadline and SetWriteDeadline.
+func (c *timedConn) Write(b []byte) (n int, err error) {
+	// First write after response starts next request, first request also includes dialing.
+	// Reads and writes are concurrent with pipelining.
+	c.mu.Lock()
+	if c.reading {
+		c.reading = false
+		c.reqStart = time.Now()
+	}
+	c.mu.Unlock()
+
+	return c.Conn.Write(b)
+}
+
+// timedTLSConn exposes Handshake so that fasthttp does not wrap connection with TLS again.
+type timedTLSConn struct {
+	*timedConn
+
+	tc *tls.Conn
+}
+
+// Handshake runs the client handshake if it has not yet been run.
+func (c timedTLSConn) Handshake() error {
+	return c.tc.Handshake()
+}
+
+// timeoutError marks connection timeouts.
+type timeoutError struct {
+	error
+}
+
+func (timeoutError) Timeout() bool {
+	return true
+}
+
+func (e timeoutError) Unwrap() error {
+	return e.error
+}
+
 // NewJobProducer creates load generator.
 func NewJobProducer(f nethttp.Flags, lf loadgen.Flags, options ...func(lf *loadgen.Flags, f *nethttp.Flags, j loadgen.JobProducer)) (*JobProducer, error) {
 	u, err := url.Parse(f.URL)
@@ -80,35 +182,95 @@ func NewJobProducer(f nethttp.Flags, lf loadgen.Flags, options ...func(lf *loadg
 		return nil, fmt.Errorf("failed to parse URL: %w", err)
 	}
 
-	addrs, err := net.LookupHost(u.Hostname())
+	if f.Stream != "" {
+		return nil, errors.New("streaming responses are not supported with fasthttp")
+	}
+
+	dialer, err := nethttp.NewDialer(f)
 	if err != nil {
-		return nil, fmt.Errorf("failed to resolve URL host: %w", err)
+		return nil, err
 	}
 
 	j := JobProducer{}
 	j.f = f
+	j.dialer = dialer
+
+	if p := dialer.Proxy(); p != nil {
+		j.log += fmt.Sprintln("Proxy:", p.Redacted())
+	} else if us := dialer.UnixSocket(); us != "" {
+		j.log += fmt.Sprintln("Unix socket:", us)
+	} else {
+		addrs, err := net.LookupHost(u.Hostname())
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve URL host: %w", err)
+		}
+
+		j.log += fmt.Sprintln("Host resolved:", strings.Join(addrs, ","))
+	}
 
-	j.log += fmt.Sprintln("Host resolved:", strings.Join(addrs, ","))
+	if sa := dialer.SourceAddr(); sa != "" {
+		j.log += fmt.Sprintln("Source addresses:", sa)
+	}
 
 	j.respCode = make(map[int]int, 5)
 	j.respBody = make(map[int][]byte, 5)
+	j.respHeader = make(map[int][]byte, 5)
+	j.dnsHist = &dynhist.Collector{BucketsLimit: 10, WeightFunc: dynhist.LatencyWidth}
+	j.connHist = &dynhist.Collector{BucketsLimit: 10, WeightFunc: dynhist.LatencyWidth}
+	j.tlsHist = &dynhist.Collector{BucketsLimit: 10, WeightFunc: dynhist.LatencyWidth}
+	j.ttfbHist = &dynhist.Collector{BucketsLimit: 10, WeightFunc: dynhist.LatencyWidth}
+	j.proxyHist = &dynhist.Collector{BucketsLimit: 10, WeightFunc: dynhist.LatencyWidth}
+	j.upstreamHist = &dynhist.Collector{BucketsLimit: 10, WeightFunc: dynhist.LatencyWidth}
+	j.upstreamHistPrecise = &dynhist.Collector{BucketsLimit: 100, WeightFunc: dynhist.LatencyWidth}
 
 	if f.Body != "" {
 		j.body = []byte(f.Body)
 	}
 
+	j.tls = u.Scheme == "https"
+
+	if j.headers, err = nethttp.NewHeaderStats(f); err != nil {
+		return nil, err
+	}
+
+	j.sampleSize = nethttp.SampleSize
+	if f.SampleSize > 0 {
+		j.sampleSize = f.SampleSize
+	}
+
+	if j.sampler, err = nethttp.NewSampler(f); err != nil {
+		return nil, err
+	}
+
+	if j.sampler != nil {
+		j.log += fmt.Sprintln("Samples directory:", j.sampler.Dir())
+	}
+
+	j.slowest = nethttp.NewSlowest(f)
+
+	if j.traces, err = nethttp.NewTraceContext(f, lf.SlowResponse); err != nil {
+		return nil, err
+	}
+
+	if j.otlp, err = nethttp.NewOTLPExporter(f, j.traces); err != nil {
+		return nil, err
+	}
+
+	dialer.OnProxyConnect = func(elapsed time.Duration) {
+		j.proxyHist.Add(1000 * elapsed.Seconds())
+	}
+
 	j.client = &fasthttp.Client{}
-	j.client.Dial = func(addr string) (net.Conn, error) {
-		c, err := fasthttp.Dial(addr)
-		if err != nil {
-			return c, err
-		}
 
-		return countingConn{
-			j:    &j,
-			Conn: c,
-		}, nil
+	if f.Insecure || f.TLSResume {
+		j.client.TLSConfig = &tls.Config{InsecureSkipVerify: f.Insecure} //nolint:gosec // Allow insecure mode in a dev tool.
+
+		if f.TLSResume {
+			j.client.TLSConfig.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+		}
 	}
+
+	j.client.Dial = j.dial
 	j.client.MaxConnsPerHost = 10000
 
 	for _, o := range options {
@@ -119,9 +281,131 @@ func NewJobProducer(f nethttp.Flags, lf loadgen.Flags, options ...func(lf *loadg
 		j.client.Name = "plt"
 	}
 
+	j.do = j.client
+	j.out = lf.Output
+
+	if f.Pipeline > 0 {
+		j.makePipeline(u, lf.Concurrency)
+		j.do = j.pipeline.client
+	}
+
 	return &j, nil
 }
 
+// dial establishes connection and collects DNS, connect and TLS handshake latencies.
+func (j *JobProducer) dial(addr string) (net.Conn, error) {
+	var (
+		mu                  sync.Mutex // Connect attempts may run concurrently for multiple addresses.
+		dnsStart, connStart time.Time
+	)
+
+	start := time.Now()
+
+	ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		DNSStart: func(_ httptrace.DNSStartInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			dnsStart = time.Now()
+		},
+		DNSDone: func(_ httptrace.DNSDoneInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			j.dnsHist.Add(1000 * time.Since(dnsStart).Seconds())
+		},
+		ConnectStart: func(_, _ string) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			connStart = time.Now()
+		},
+		ConnectDone: func(_, _ string, err error) {
+			if err != nil {
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			j.connHist.Add(1000 * time.Since(connStart).Seconds())
+		},
+	})
+
+	// Proxy and unix socket dial their own addresses, host name of original address is kept for TLS.
+	dialAddr := addr
+
+	if j.dialer.Proxy() == nil && j.dialer.UnixSocket() == "" {
+		var err error
+
+		if dialAddr, err = j.dns.resolve(ctx, addr); err != nil {
+			return nil, err
+		}
+	}
+
+	c, err := j.dialer.DialContext(ctx, "tcp", dialAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	c = countingConn{j: j, Conn: c}
+
+	if !j.tls {
+		return &timedConn{Conn: c, j: j, reqStart: start}, nil
+	}
+
+	cfg := &tls.Config{} //nolint:gosec // Default min version.
+	if j.client.TLSConfig != nil {
+		cfg = j.client.TLSConfig.Clone()
+	}
+
+	if cfg.ServerName == "" {
+		cfg.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+
+	hctx := context.Background()
+
+	if j.f.ConnectTimeout > 0 {
+		var cancel func()
+
+		hctx, cancel = context.WithTimeout(hctx, j.f.ConnectTimeout)
+		defer cancel()
+	}
+
+	tlsStart := time.Now()
+	tc := tls.Client(c, cfg)
+
+	if err := tc.HandshakeContext(hctx); err != nil {
+		_ = c.Close()
+
+		return nil, err
+	}
+
+	j.tlsHist.Add(1000 * time.Since(tlsStart).Seconds())
+
+	return timedTLSConn{timedConn: &timedConn{Conn: tc, j: j, reqStart: start}, tc: tc}, nil
+}
+
+// Close exports collected data, it should be called after loadgen.Run.
+func (j *JobProducer) Close() error {
+	out := j.out
+	if out == nil {
+		out = os.Stdout
+	}
+
+	if j.otlp != nil {
+		j.otlp.Flush()
+
+		_, _ = fmt.Fprint(out, j.otlp.String())
+	}
+
+	if j.slowest != nil {
+		return j.slowest.Export(out)
+	}
+
+	return nil
+}
+
 // String reports results.
 func (j *JobProducer) String() string {
 	j.mu.Lock()
@@ -133,10 +417,15 @@ func (j *JobProducer) String() string {
 
 	for code, cnt := range j.respCode {
 		codes += fmt.Sprintf("[%d] %d\n", code, cnt)
-		resps += fmt.Sprintf("[%d]\n%s\n", code, string(j.respBody[code]))
+		resps += fmt.Sprintf("[%d]\n%s%s\n", code, string(j.respHeader[code]), string(j.respBody[code]))
 	}
 
 	if codes == "" {
+		// Trace IDs of failed requests are still reported when there were no responses.
+		if j.traces != nil {
+			return res + j.traces.String()
+		}
+
 		return ""
 	}
 
@@ -148,6 +437,67 @@ func (j *JobProducer) String() string {
 	res += fmt.Sprintln("Bytes read", report.ByteSize(atomic.LoadInt64(&j.bytesRead)))
 	res += fmt.Sprintln("Bytes written", report.ByteSize(atomic.LoadInt64(&j.bytesWritten)))
 
+	if cs := j.compression.String(); cs != "" {
+		res += "\n" + cs
+	}
+
+	if lr := j.dialer.LimitRate(); lr > 0 {
+		res += fmt.Sprintln("Per-connection throughput", report.ByteSize(int64(j.dialer.Throughput().BytesPerSecond()))+"/s",
+			"avg, limited to", report.ByteSize(lr)+"/s")
+	}
+
+	res += "\n"
+
+	if j.pipeline != nil {
+		res += j.pipeline.String()
+	}
+
+	if j.upstreamHist.Count > 0 {
+		res += "Envoy upstream latency percentiles:\n"
+		res += fmt.Sprintf("99%%: %.0fms\n", j.upstreamHistPrecise.Percentile(99))
+		res += fmt.Sprintf("95%%: %.0fms\n", j.upstreamHistPrecise.Percentile(95))
+		res += fmt.Sprintf("90%%: %.0fms\n", j.upstreamHistPrecise.Percentile(90))
+		res += fmt.Sprintf("50%%: %.0fms\n\n", j.upstreamHistPrecise.Percentile(50))
+
+		res += "Envoy upstream latency distribution in ms:\n"
+		res += j.upstreamHist.String() + "\n"
+	}
+
+	res += j.headers.String()
+
+	if j.slowest != nil {
+		res += j.slowest.String()
+	}
+
+	if j.traces != nil {
+		res += j.traces.String()
+	}
+
+	if j.dnsHist.Count > 0 {
+		res += "DNS latency distribution in ms:\n"
+		res += j.dnsHist.String() + "\n"
+	}
+
+	if j.tlsHist.Count > 0 {
+		res += "TLS handshake latency distribution in ms:\n"
+		res += j.tlsHist.String() + "\n"
+	}
+
+	if j.ttfbHist.Count > 0 {
+		res += "Time to first resp byte (TTFB) distribution in ms:\n"
+		res += j.ttfbHist.String() + "\n"
+	}
+
+	if j.connHist.Count > 0 {
+		res += "Connection latency distribution in ms:\n"
+		res += j.connHist.String() + "\n"
+	}
+
+	if j.proxyHist.Count > 0 {
+		res += "Proxy connect latency distribution in ms:\n"
+		res += j.proxyHist.String() + "\n"
+	}
+
 	res += fmt.Sprintln(resps)
 
 	return res
@@ -155,6 +505,17 @@ func (j *JobProducer) String() string {
 
 // Job sends a single http request.
 func (j *JobProducer) Job(i int) (time.Duration, error) {
+	if j.traces == nil {
+		return j.job(i)
+	}
+
+	elapsed, err := j.job(i)
+	j.traces.Done(i, elapsed, err)
+
+	return elapsed, err
+}
+
+func (j *JobProducer) job(i int) (time.Duration, error) {
 	start := time.Now()
 
 	req := fasthttp.AcquireRequest()
@@ -174,32 +535,162 @@ func (j *JobProducer) Job(i int) (time.Duration, error) {
 		req.Header.Set(k, v)
 	}
 
+	if j.traces != nil {
+		j.traces.Headers(i, req.Header.Set)
+	}
+
 	if j.PrepareRequest != nil {
 		if err := j.PrepareRequest(i, req); err != nil {
 			return 0, err
 		}
 	}
 
-	err := j.client.Do(req, resp)
+	if j.pipeline != nil {
+		j.pipeline.sampleDepth()
+	}
+
+	var err error
+
+	if j.f.MaxTime > 0 {
+		err = j.do.DoTimeout(req, resp, j.f.MaxTime)
+	} else {
+		err = j.do.Do(req, resp)
+	}
+
 	if err != nil {
+		if errors.Is(err, fasthttp.ErrDialTimeout) || errors.Is(err, fasthttp.ErrTLSHandshakeTimeout) {
+			err = timeoutError{err}
+		}
+
+		if j.sampler != nil {
+			if serr := j.sampler.Save(0, time.Since(start), func() []byte {
+				return append(dumpRequest(req), "\n"+err.Error()+"\n"...)
+			}); serr != nil {
+				return 0, errors.Join(err, serr)
+			}
+		}
+
+		if j.slowest != nil {
+			j.slowest.Add(time.Since(start), func() nethttp.SlowRequest {
+				r := nethttp.SlowRequest{Index: i, Start: start, Error: err.Error()}
+				r.RequestID, r.TraceParent = nethttp.TraceIDs(headerGetter(&req.Header))
+
+				return r
+			})
+		}
+
+		if j.otlp != nil {
+			j.otlp.Add(nethttp.RequestSpan{Index: i, Method: j.f.Method, URL: j.f.URL, Start: start, End: time.Now(), Err: err})
+		}
+
 		return 0, err
 	}
 
 	si := time.Since(start)
 
+	if envoyUpstreamMS := resp.Header.Peek("X-Envoy-Upstream-Service-Time"); len(envoyUpstreamMS) > 0 {
+		ms, err := strconv.Atoi(string(envoyUpstreamMS))
+		if err == nil {
+			j.upstreamHist.Add(float64(ms))
+			j.upstreamHistPrecise.Add(float64(ms))
+		}
+	}
+
+	if !j.headers.Empty() {
+		j.headers.Add(func(name string) string {
+			return string(resp.Header.Peek(name))
+		})
+	}
+
+	var decoded []byte
+
+	if ce := string(resp.Header.ContentEncoding()); ce != "" && nethttp.DecodingSupported(ce) {
+		enc := strings.ToLower(ce)
+
+		// Decoder of fasthttp only recognizes canonical names, original header is kept for report.
+		if enc == "x-gzip" {
+			resp.Header.SetContentEncoding("gzip")
+		} else {
+			resp.Header.SetContentEncoding(enc)
+		}
+
+		decodeStart := time.Now()
+		decoded, err = resp.BodyUncompressed()
+
+		resp.Header.SetContentEncoding(ce)
+
+		if err != nil {
+			return 0, fmt.Errorf("failed to decode %s response: %w", ce, err)
+		}
+
+		j.compression.Add(enc, int64(len(resp.Body())), int64(len(decoded)), time.Since(decodeStart))
+	}
+
+	body := resp.Body()
+	if decoded != nil {
+		body = decoded
+	}
+
 	j.mu.Lock()
 	j.respCode[resp.StatusCode()]++
 
 	if j.respCode[resp.StatusCode()] == 1 {
-		body := resp.Body()
-
-		if len(resp.Header.Peek("Content-Encoding")) > 0 {
+		if decoded == nil && len(resp.Header.Peek("Content-Encoding")) > 0 {
 			j.respBody[resp.StatusCode()] = []byte("<" + string(resp.Header.Peek("Content-Encoding")) + "-encoded-content>")
 		} else {
-			j.respBody[resp.StatusCode()] = report.PeekBody(body, 1000)
+			j.respBody[resp.StatusCode()] = report.PeekBody(append([]byte(nil), body...), j.sampleSize)
 		}
+
+		j.respHeader[resp.StatusCode()] = append([]byte(nil), resp.Header.Header()...)
 	}
 	j.mu.Unlock()
 
+	if j.sampler != nil {
+		if err := j.sampler.Save(resp.StatusCode(), si, func() []byte {
+			res := append(dumpRequest(req), '\n')
+			res = append(res, resp.Header.Header()...)
+
+			return append(res, body[:min(len(body), j.sampler.Size())]...)
+		}); err != nil {
+			return 0, err
+		}
+	}
+
+	if j.slowest != nil {
+		j.slowest.Add(si, func() nethttp.SlowRequest {
+			r := nethttp.SlowRequest{Index: i, Start: start, Status: resp.StatusCode(), Header: make(http.Header)}
+			r.RequestID, r.TraceParent = nethttp.TraceIDs(headerGetter(&req.Header), headerGetter(&resp.Header))
+
+			resp.Header.VisitAll(func(k, v []byte) {
+				r.Header.Add(string(k), string(v))
+			})
+
+			return r
+		})
+	}
+
+	if j.otlp != nil {
+		j.otlp.Add(nethttp.RequestSpan{Index: i, Method: j.f.Method, URL: j.f.URL, Start: start, End: start.Add(si), Status: resp.StatusCode()})
+	}
+
 	return si, nil
 }
+
+// dumpRequest renders request headers and body.
+func dumpRequest(req *fasthttp.Request) []byte {
+	res := append([]byte(nil), req.Header.Header()...)
+
+	if body := req.Body(); len(body) > 0 {
+		res = append(res, body...)
+		res = append(res, '\n')
+	}
+
+	return res
+}
+
+// headerGetter returns header value getter.
+func headerGetter(h interface{ Peek(key string) []byte }) func(string) string {
+	return func(name string) string {
+		return string(h.Peek(name))
+	}
+}
@@ -2,8 +2,18 @@ package fasthttp_test
 
 import (
 	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -91,3 +101,374 @@ func BenchmarkJobProducer_Job(b *testing.B) {
 	b.ReportAllocs()
 	require.NoError(b, loadgen.Run(lf, j))
 }
+
+func TestNewJobProducer_breakdown(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set("X-Envoy-Upstream-Service-Time", "12")
+	}))
+	defer srv.Close()
+
+	out := bytes.NewBuffer(nil)
+
+	lf := loadgen.Flags{
+		Number:       20,
+		Concurrency:  2,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       out,
+	}
+	f := nethttp.Flags{
+		HeaderMap: map[string]string{},
+		URL:       srv.URL,
+		Method:    http.MethodGet,
+		Insecure:  true,
+	}
+	j, err := fh.NewJobProducer(f, lf)
+	require.NoError(t, err)
+
+	require.NoError(t, loadgen.Run(lf, j))
+	assert.Equal(t, map[string]int{"200": 20}, j.RequestCounts())
+	assert.Contains(t, out.String(), "Envoy upstream latency percentiles:\n99%: 12ms")
+	assert.Contains(t, out.String(), "TLS handshake latency distribution in ms:")
+	assert.Contains(t, out.String(), "Time to first resp byte (TTFB) distribution in ms:\n")
+	assert.Regexp(t, `TTFB\) distribution in ms:\n\[.+\] cnt total% \(20 events\)`, out.String())
+	assert.Contains(t, out.String(), "Connection latency distribution in ms:")
+}
+
+func TestNewJobProducer_dnsCache(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set("Connection", "close")
+	}))
+	defer srv.Close()
+
+	out := bytes.NewBuffer(nil)
+
+	lf := loadgen.Flags{
+		Number:       5,
+		Concurrency:  1,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       out,
+	}
+	f := nethttp.Flags{
+		HeaderMap: map[string]string{},
+		URL:       strings.Replace(srv.URL, "127.0.0.1", "localhost", 1),
+		Method:    http.MethodGet,
+	}
+	j, err := fh.NewJobProducer(f, lf)
+	require.NoError(t, err)
+
+	// Every request has new connection, but host is resolved once.
+	require.NoError(t, loadgen.Run(lf, j))
+	assert.Equal(t, map[string]int{"200": 5}, j.RequestCounts())
+	assert.Regexp(t, `DNS latency distribution in ms:\n.+\(1 events\)\n`, out.String())
+	assert.Regexp(t, `Connection latency distribution in ms:\n.+\(5 events\)\n`, out.String())
+}
+
+func TestNewJobProducer_pipeline(t *testing.T) {
+	var conns int64
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&conns, 1)
+		}
+	}
+	srv.Start()
+
+	defer srv.Close()
+
+	out := bytes.NewBuffer(nil)
+
+	lf := loadgen.Flags{
+		Number:       100,
+		Concurrency:  8,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       out,
+	}
+	f := nethttp.Flags{
+		HeaderMap: map[string]string{},
+		URL:       srv.URL,
+		Method:    http.MethodGet,
+		Pipeline:  4,
+	}
+	j, err := fh.NewJobProducer(f, lf)
+	require.NoError(t, err)
+
+	require.NoError(t, loadgen.Run(lf, j))
+	assert.Equal(t, map[string]int{"200": 100}, j.RequestCounts())
+	assert.LessOrEqual(t, atomic.LoadInt64(&conns), int64(2))
+	assert.Contains(t, out.String(), "Pipelining: 2 connections, 4 max pending requests per connection")
+	assert.Contains(t, out.String(), "Average pending requests per connection (total / connections) distribution:")
+}
+
+func TestNewJobProducer_collectHeader(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set("X-Cache", "HIT")
+		rw.Header().Set("X-Upstream-Time", "25")
+	}))
+	defer srv.Close()
+
+	out := bytes.NewBuffer(nil)
+
+	lf := loadgen.Flags{
+		Number:       20,
+		Concurrency:  2,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       out,
+	}
+	f := nethttp.Flags{
+		HeaderMap:            map[string]string{},
+		URL:                  srv.URL,
+		Method:               http.MethodGet,
+		CollectHeader:        []string{"X-Cache"},
+		CollectHeaderLatency: []string{"X-Upstream-Time"},
+	}
+	j, err := fh.NewJobProducer(f, lf)
+	require.NoError(t, err)
+
+	require.NoError(t, loadgen.Run(lf, j))
+	assert.Contains(t, out.String(), "Responses by X-Cache header value:\n[HIT] 20\n")
+	assert.Contains(t, out.String(), "X-Upstream-Time latency percentiles:\n99%: 25.00ms")
+}
+
+func TestNewJobProducer_sampleDir(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		_, _ = rw.Write([]byte("hello world"))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	out := bytes.NewBuffer(nil)
+
+	lf := loadgen.Flags{
+		Number:       10,
+		Concurrency:  2,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       out,
+	}
+	f := nethttp.Flags{
+		HeaderMap:   map[string]string{},
+		URL:         srv.URL + "/foo",
+		Method:      http.MethodGet,
+		SampleDir:   dir,
+		SampleCount: 3,
+		SampleSize:  5,
+	}
+	j, err := fh.NewJobProducer(f, lf)
+	require.NoError(t, err)
+
+	require.NoError(t, loadgen.Run(lf, j))
+	assert.Contains(t, out.String(), "HTTP/1.1 200 OK\r\n")
+	assert.Contains(t, out.String(), "hello...")
+
+	for _, name := range []string{"200-1.txt", "200-2.txt", "200-3.txt", "slowest.txt"} {
+		sample, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		assert.Contains(t, string(sample), "GET /foo HTTP/1.1\r\n")
+		assert.True(t, strings.HasSuffix(string(sample), "\r\n\r\nhello"))
+	}
+
+	assert.NoFileExists(t, filepath.Join(dir, "200-4.txt"))
+}
+
+func TestNewJobProducer_compressed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		// Legacy alias is decoded as gzip.
+		w.Header().Set("Content-Encoding", "x-gzip")
+
+		gw := gzip.NewWriter(w)
+		_, err := gw.Write([]byte(strings.Repeat("hello ", 1000)))
+		assert.NoError(t, err)
+		assert.NoError(t, gw.Close())
+	}))
+	defer srv.Close()
+
+	out := bytes.NewBuffer(nil)
+
+	lf := loadgen.Flags{
+		Number:       10,
+		Concurrency:  2,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       out,
+	}
+	f := nethttp.Flags{
+		HeaderMap:  map[string]string{"Accept-Encoding": "gzip"},
+		URL:        srv.URL,
+		Method:     http.MethodGet,
+		Compressed: true,
+	}
+	j, err := fh.NewJobProducer(f, lf)
+	require.NoError(t, err)
+
+	require.NoError(t, loadgen.Run(lf, j))
+	assert.Equal(t, map[string]int{"200": 10}, j.RequestCounts())
+	assert.Contains(t, out.String(), "[x-gzip] 10, wire ")
+	assert.Contains(t, out.String(), "Content-Encoding: x-gzip")
+	assert.Contains(t, out.String(), "hello hello")
+}
+
+func TestNewJobProducer_slowest(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Request-Id") == "req-3" {
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		rw.Header().Set("X-Foo", "bar")
+		_, _ = rw.Write([]byte("hello world"))
+	}))
+	defer srv.Close()
+
+	file := filepath.Join(t.TempDir(), "slowest.json")
+	out := bytes.NewBuffer(nil)
+
+	lf := loadgen.Flags{
+		Number:       10,
+		Concurrency:  2,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       out,
+	}
+	f := nethttp.Flags{
+		HeaderMap:   map[string]string{},
+		URL:         srv.URL,
+		Method:      http.MethodGet,
+		SlowestJSON: file,
+	}
+	j, err := fh.NewJobProducer(f, lf)
+	require.NoError(t, err)
+
+	j.PrepareRequest = func(i int, req *fasthttp.Request) error {
+		req.Header.Set("X-Request-Id", "req-"+strconv.Itoa(i))
+
+		return nil
+	}
+
+	require.NoError(t, loadgen.Run(lf, j))
+	assert.Regexp(t, `Slowest requests:\n\[200\] 1\d\d\.\d\dms #3 at [\d:.]+, X-Request-Id: req-3\n`, out.String())
+	require.NoError(t, j.Close())
+	assert.Contains(t, out.String(), "Slowest requests exported to "+file+"\n")
+
+	b, err := os.ReadFile(file)
+	require.NoError(t, err)
+
+	var slowest []nethttp.SlowRequest
+
+	require.NoError(t, json.Unmarshal(b, &slowest))
+	require.Len(t, slowest, 10)
+	assert.Equal(t, 3, slowest[0].Index)
+	assert.Equal(t, "req-3", slowest[0].RequestID)
+	assert.Equal(t, "bar", slowest[0].Header.Get("X-Foo"))
+}
+
+func TestNewJobProducer_slowestFailed(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	file := filepath.Join(t.TempDir(), "slowest.json")
+	out := bytes.NewBuffer(nil)
+
+	lf := loadgen.Flags{
+		Number:       5,
+		Concurrency:  1,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       out,
+	}
+	f := nethttp.Flags{
+		HeaderMap:   map[string]string{},
+		URL:         "http://" + l.Addr().String() + "/",
+		Method:      http.MethodGet,
+		SlowestJSON: file,
+	}
+	j, err := fh.NewJobProducer(f, lf)
+	require.NoError(t, err)
+
+	require.EqualError(t, loadgen.Run(lf, j), "all requests failed: dial tcp "+l.Addr().String()+": connect: connection refused")
+	assert.Empty(t, j.RequestCounts())
+
+	require.NoError(t, j.Close())
+	assert.Contains(t, out.String(), "Slowest requests exported to "+file+"\n")
+
+	b, err := os.ReadFile(file)
+	require.NoError(t, err)
+
+	var slowest []nethttp.SlowRequest
+
+	require.NoError(t, json.Unmarshal(b, &slowest))
+	require.Len(t, slowest, 5)
+	assert.NotEmpty(t, slowest[0].Error)
+	assert.Zero(t, slowest[0].Status)
+}
+
+func TestNewJobProducer_traceParent(t *testing.T) {
+	var ids sync.Map
+
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		ids.Store(r.Header.Get("X-Request-Id"), r.Header.Get("Traceparent"))
+	}))
+	defer srv.Close()
+
+	out := bytes.NewBuffer(nil)
+
+	lf := loadgen.Flags{
+		Number:       10,
+		Concurrency:  2,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       out,
+	}
+	f := nethttp.Flags{
+		HeaderMap:   map[string]string{},
+		URL:         srv.URL,
+		Method:      http.MethodGet,
+		TraceParent: true,
+		RequestID:   true,
+		RunID:       "test-run",
+	}
+	j, err := fh.NewJobProducer(f, lf)
+	require.NoError(t, err)
+
+	require.NoError(t, loadgen.Run(lf, j))
+	assert.Contains(t, out.String(), "Run ID: test-run\n")
+
+	for i := range 10 {
+		tp, ok := ids.Load("test-run-" + strconv.Itoa(i))
+		require.True(t, ok)
+		assert.Regexp(t, fmt.Sprintf(`^00-[0-9a-f]{16}%016x-%016x-01$`, i, i+1), tp)
+	}
+}
+
+func TestNewJobProducer_traceFailed(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	out := bytes.NewBuffer(nil)
+
+	lf := loadgen.Flags{
+		Number:       3,
+		Concurrency:  1,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       out,
+	}
+	f := nethttp.Flags{
+		HeaderMap: map[string]string{},
+		URL:       "http://" + l.Addr().String() + "/",
+		Method:    http.MethodGet,
+		RequestID: true,
+		RunID:     "test-run",
+	}
+	j, err := fh.NewJobProducer(f, lf)
+	require.NoError(t, err)
+
+	require.Error(t, loadgen.Run(lf, j))
+	assert.Contains(t, out.String(), "Run ID: test-run\n")
+	assert.Regexp(t, `Trace IDs of failed requests \(3\):\n#0 test-run-0 .+\n#1 test-run-1 .+\n#2 test-run-2 .+\n`, out.String())
+}
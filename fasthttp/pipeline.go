@@ -0,0 +1,67 @@
+package fasthttp
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/vearutop/dynhist-go"
+)
+
+// doer is implemented by fasthttp.Client and fasthttp.PipelineClient.
+type doer interface {
+	Do(req *fasthttp.Request, resp *fasthttp.Response) error
+	DoTimeout(req *fasthttp.Request, resp *fasthttp.Response, timeout time.Duration) error
+}
+
+// pipeline sends requests with HTTP/1.1 pipelining.
+type pipeline struct {
+	client *fasthttp.PipelineClient
+	conns  int
+
+	depthHist *dynhist.Collector
+}
+
+func (j *JobProducer) makePipeline(u *url.URL, concurrency int) {
+	conns := j.f.PipelineConns
+	if conns <= 0 {
+		if concurrency <= 0 {
+			concurrency = 50
+		}
+
+		// Enough connections to serve all concurrent requests without queue overflow.
+		conns = (concurrency + j.f.Pipeline - 1) / j.f.Pipeline
+	}
+
+	j.pipeline = &pipeline{
+		client: &fasthttp.PipelineClient{
+			Addr:               fasthttp.AddMissingPort(u.Host, j.tls),
+			Name:               j.client.Name,
+			Dial:               j.dial,
+			IsTLS:              j.tls,
+			TLSConfig:          j.client.TLSConfig,
+			MaxConns:           conns,
+			MaxPendingRequests: j.f.Pipeline,
+		},
+		conns:     conns,
+		depthHist: &dynhist.Collector{BucketsLimit: 10},
+	}
+}
+
+// sampleDepth registers average number of pending requests per connection.
+//
+// PipelineClient does not expose pending requests of individual connections,
+// so total is divided by number of connections.
+func (p *pipeline) sampleDepth() {
+	p.depthHist.Add(float64(p.client.PendingRequests()) / float64(p.conns))
+}
+
+// String renders pipelining report.
+func (p *pipeline) String() string {
+	res := fmt.Sprintln("Pipelining:", p.conns, "connections,", p.client.MaxPendingRequests, "max pending requests per connection")
+	res += "\nAverage pending requests per connection (total / connections) distribution:\n"
+	res += p.depthHist.String() + "\n"
+
+	return res
+}
@@ -0,0 +1,76 @@
+package fasthttp
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// resolver caches resolved addresses like fasthttp.Dial does, so that DNS is not queried for every connection.
+type resolver struct {
+	mu    sync.Mutex
+	hosts map[string]*resolvedHost
+}
+
+type resolvedHost struct {
+	ips     []net.IP
+	expires time.Time
+	next    int
+}
+
+// resolve replaces host name of address with cached IP, addresses are rotated between connections.
+//
+// IPv4 addresses are preferred, as with fasthttp.Dial. Lookup is traced with context.
+func (r *resolver) resolve(ctx context.Context, addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+
+	if net.ParseIP(host) != nil {
+		return addr, nil
+	}
+
+	r.mu.Lock()
+	h := r.hosts[host]
+
+	if h != nil && time.Now().Before(h.expires) {
+		ip := h.ips[h.next%len(h.ips)]
+		h.next++
+		r.mu.Unlock()
+
+		return net.JoinHostPort(ip.String(), port), nil
+	}
+	r.mu.Unlock()
+
+	resolved, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return "", err
+	}
+
+	ips := make([]net.IP, 0, len(resolved))
+
+	for _, ip := range resolved {
+		if ip.To4() != nil {
+			ips = append(ips, ip)
+		}
+	}
+
+	if len(ips) == 0 {
+		ips = resolved
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.hosts == nil {
+		r.hosts = make(map[string]*resolvedHost)
+	}
+
+	r.hosts[host] = &resolvedHost{ips: ips, expires: time.Now().Add(fasthttp.DefaultDNSCacheDuration), next: 1}
+
+	return net.JoinHostPort(ips[0].String(), port), nil
+}
@@ -0,0 +1,96 @@
+// Package grpc implements gRPC load tester command.
+package grpc
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/vearutop/plt/loadgen"
+)
+
+// Flags describes gRPC command parameters.
+type Flags struct {
+	Target   string
+	Method   string
+	Data     string
+	Metadata map[string]string
+
+	Proto      []string
+	ImportPath []string
+	Protoset   []string
+
+	Plaintext      bool
+	Insecure       bool
+	Authority      string
+	Connections    int
+	ConnectTimeout time.Duration
+	MaxTime        time.Duration
+}
+
+// AddCommand registers grpc command into CLI app.
+func AddCommand(lf *loadgen.Flags) {
+	var (
+		f      Flags
+		header []string
+	)
+
+	g := kingpin.Command("grpc", "gRPC unary or server streaming calls")
+	g.Flag("data", "Request message in JSON, @FILE to read from file.").Short('d').Default("{}").StringVar(&f.Data)
+	g.Flag("header", "Request metadata, e.g. 'Authorization: Bearer token'.").Short('H').StringsVar(&header)
+
+	g.Flag("proto", "Proto file with service definition, server reflection is used if no proto or protoset is set.").
+		PlaceHolder("FILE").StringsVar(&f.Proto)
+	g.Flag("import-path", "Path to look for proto imports.").PlaceHolder("DIR").StringsVar(&f.ImportPath)
+	g.Flag("protoset", "File with compiled FileDescriptorSet, e.g. from protoc --descriptor_set_out.").
+		PlaceHolder("FILE").StringsVar(&f.Protoset)
+
+	g.Flag("plaintext", "Use plain-text HTTP/2 without TLS.").BoolVar(&f.Plaintext)
+	g.Flag("insecure", "Skip server certificate verification.").Short('k').BoolVar(&f.Insecure)
+	g.Flag("authority", "Value of :authority pseudo-header and TLS server name.").StringVar(&f.Authority)
+	g.Flag("connections", "Number of connections to round-robin calls across.").Default("1").IntVar(&f.Connections)
+	g.Flag("connect-timeout", "Max time to establish connection.").Default("30s").DurationVar(&f.ConnectTimeout)
+	g.Flag("max-time", "Deadline of a single call, 0 disables deadline.").DurationVar(&f.MaxTime)
+
+	g.Arg("target", "Server address, e.g. localhost:50051.").Required().StringVar(&f.Target)
+	g.Arg("method", "Full method name, e.g. grpc.health.v1.Health/Check.").Required().StringVar(&f.Method)
+
+	g.Action(func(_ *kingpin.ParseContext) error {
+		if strings.HasPrefix(f.Data, "@") {
+			b, err := os.ReadFile(f.Data[1:])
+			if err != nil {
+				return fmt.Errorf("failed to read request data: %w", err)
+			}
+
+			f.Data = string(b)
+		}
+
+		f.Metadata = make(map[string]string, len(header))
+
+		for _, h := range header {
+			name, value, ok := strings.Cut(h, ":")
+			if !ok {
+				return fmt.Errorf("invalid header %q", h)
+			}
+
+			f.Metadata[strings.ToLower(strings.TrimSpace(name))] = strings.TrimSpace(value)
+		}
+
+		return run(*lf, f)
+	})
+}
+
+func run(lf loadgen.Flags, f Flags) error {
+	lf.Prepare()
+
+	j, err := NewJobProducer(f)
+	if err != nil {
+		return err
+	}
+
+	defer j.Close()
+
+	return loadgen.Run(lf, j)
+}
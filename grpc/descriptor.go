@@ -0,0 +1,243 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/grpc"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// splitMethod parses "package.Service/Method" or "package.Service.Method".
+func splitMethod(method string) (service, name string, err error) {
+	method = strings.TrimPrefix(method, "/")
+
+	pos := strings.LastIndex(method, "/")
+	if pos == -1 {
+		pos = strings.LastIndex(method, ".")
+	}
+
+	if pos <= 0 || pos == len(method)-1 {
+		return "", "", fmt.Errorf("invalid method %q, expected package.Service/Method", method)
+	}
+
+	return method[:pos], method[pos+1:], nil
+}
+
+// findMethod resolves method descriptor from proto files, descriptor sets or server reflection.
+func findMethod(ctx context.Context, f Flags, conn grpc.ClientConnInterface) (protoreflect.MethodDescriptor, error) {
+	service, name, err := splitMethod(f.Method)
+	if err != nil {
+		return nil, err
+	}
+
+	var files linkedFiles
+
+	switch {
+	case len(f.Proto) > 0:
+		files, err = compileProto(ctx, f.ImportPath, f.Proto)
+	case len(f.Protoset) > 0:
+		files, err = loadProtoset(f.Protoset)
+	default:
+		files, err = reflectService(ctx, conn, service)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := files.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find service %s: %w", service, err)
+	}
+
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a service", service)
+	}
+
+	md := sd.Methods().ByName(protoreflect.Name(name))
+	if md == nil {
+		return nil, fmt.Errorf("failed to find method %s in service %s", name, service)
+	}
+
+	if md.IsStreamingClient() {
+		return nil, fmt.Errorf("client streaming method %s is not supported", name)
+	}
+
+	return md, nil
+}
+
+// linkedFiles is a list of resolved file descriptors.
+type linkedFiles []protoreflect.FileDescriptor
+
+// FindDescriptorByName finds service by its full name.
+func (lf linkedFiles) FindDescriptorByName(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	for _, f := range lf {
+		if d := f.Services().ByName(name.Name()); d != nil && d.FullName() == name {
+			return d, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%s not found", name)
+}
+
+func compileProto(ctx context.Context, importPaths, files []string) (linkedFiles, error) {
+	c := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{ImportPaths: importPaths}),
+	}
+
+	compiled, err := c.Compile(ctx, files...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile proto files: %w", err)
+	}
+
+	res := make(linkedFiles, 0, len(compiled))
+	for _, f := range compiled {
+		res = append(res, f)
+	}
+
+	return res, nil
+}
+
+func loadProtoset(files []string) (linkedFiles, error) {
+	fds := &descriptorpb.FileDescriptorSet{}
+
+	for _, fn := range files {
+		b, err := os.ReadFile(fn) //nolint:gosec // File is provided by user.
+		if err != nil {
+			return nil, fmt.Errorf("failed to read protoset: %w", err)
+		}
+
+		var set descriptorpb.FileDescriptorSet
+
+		if err := proto.Unmarshal(b, &set); err != nil {
+			return nil, fmt.Errorf("failed to decode protoset %s: %w", fn, err)
+		}
+
+		fds.File = append(fds.File, set.GetFile()...)
+	}
+
+	reg, err := protodesc.NewFiles(fds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load protoset: %w", err)
+	}
+
+	var res linkedFiles
+
+	reg.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		res = append(res, fd)
+
+		return true
+	})
+
+	return res, nil
+}
+
+// reflectService fetches file descriptors of a service and its dependencies with server reflection.
+func reflectService(ctx context.Context, conn grpc.ClientConnInterface, service string) (linkedFiles, error) {
+	stream, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start server reflection: %w", err)
+	}
+
+	defer func() {
+		_ = stream.CloseSend()
+	}()
+
+	seen := map[string]bool{}
+	fds := &descriptorpb.FileDescriptorSet{}
+
+	add := func(resp *rpb.ServerReflectionResponse) ([]string, error) {
+		if e := resp.GetErrorResponse(); e != nil {
+			return nil, fmt.Errorf("server reflection failed: %s", e.GetErrorMessage())
+		}
+
+		var deps []string
+
+		for _, b := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			fd := &descriptorpb.FileDescriptorProto{}
+			if err := proto.Unmarshal(b, fd); err != nil {
+				return nil, fmt.Errorf("failed to decode file descriptor: %w", err)
+			}
+
+			if seen[fd.GetName()] {
+				continue
+			}
+
+			seen[fd.GetName()] = true
+			fds.File = append(fds.File, fd)
+			deps = append(deps, fd.GetDependency()...)
+		}
+
+		return deps, nil
+	}
+
+	req := &rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: service},
+	}
+
+	var queue []string
+
+	for {
+		if err := stream.Send(req); err != nil {
+			return nil, fmt.Errorf("failed to send server reflection request: %w", err)
+		}
+
+		resp, err := stream.Recv()
+		if err != nil {
+			return nil, fmt.Errorf("failed to receive server reflection response: %w", err)
+		}
+
+		deps, err := add(resp)
+		if err != nil {
+			return nil, err
+		}
+
+		// Dependencies are usually sent along, missing ones are requested by name.
+		queue = append(queue, deps...)
+		req = nil
+
+		for len(queue) > 0 && req == nil {
+			dep := queue[0]
+			queue = queue[1:]
+
+			if !seen[dep] {
+				req = &rpb.ServerReflectionRequest{
+					MessageRequest: &rpb.ServerReflectionRequest_FileByFilename{FileByFilename: dep},
+				}
+			}
+		}
+
+		if req == nil {
+			break
+		}
+	}
+
+	reg, err := protodesc.NewFiles(fds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load reflected files: %w", err)
+	}
+
+	var res linkedFiles
+
+	reg.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		res = append(res, fd)
+
+		return true
+	})
+
+	if len(res) == 0 {
+		return nil, errors.New("no files received with server reflection")
+	}
+
+	return res, nil
+}
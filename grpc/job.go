@@ -0,0 +1,419 @@
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vearutop/dynhist-go"
+	"github.com/vearutop/plt/report"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// JobProducer makes gRPC calls over a pool of connections.
+type JobProducer struct {
+	f      Flags
+	conns  []*grpc.ClientConn
+	next   uint64
+	method string
+	md     protoreflect.MethodDescriptor
+	req    proto.Message
+	ctx    context.Context //nolint:containedctx // Base context with outgoing metadata.
+
+	connHist            *dynhist.Collector
+	tlsHist             *dynhist.Collector
+	firstMsgHist        *dynhist.Collector
+	firstMsgHistPrecise *dynhist.Collector
+	messagesHist        *dynhist.Collector
+	bytesRead           int64
+	bytesWritten        int64
+	connections         int64
+	streams             int64
+	messages            int64
+
+	mu      sync.Mutex
+	codes   map[codes.Code]int
+	samples map[codes.Code]string
+}
+
+// countingConn counts bytes of gRPC connection.
+type countingConn struct {
+	net.Conn
+	j *JobProducer
+}
+
+// Read reads data from the connection.
+func (c countingConn) Read(b []byte) (n int, err error) {
+	n, err = c.Conn.Read(b)
+	atomic.AddInt64(&c.j.bytesRead, int64(n))
+
+	return n, err
+}
+
+// Write writes data to the connection.
+func (c countingConn) Write(b []byte) (n int, err error) {
+	n, err = c.Conn.Write(b)
+	atomic.AddInt64(&c.j.bytesWritten, int64(n))
+
+	return n, err
+}
+
+// timedCredentials measures duration of TLS handshake.
+type timedCredentials struct {
+	credentials.TransportCredentials
+	j *JobProducer
+}
+
+// ClientHandshake does TLS handshake.
+func (c timedCredentials) ClientHandshake(
+	ctx context.Context, authority string, conn net.Conn,
+) (net.Conn, credentials.AuthInfo, error) {
+	start := time.Now()
+
+	tc, ai, err := c.TransportCredentials.ClientHandshake(ctx, authority, conn)
+	if err == nil {
+		c.j.tlsHist.Add(1000 * time.Since(start).Seconds())
+	}
+
+	return tc, ai, err
+}
+
+// Clone makes a copy of credentials.
+func (c timedCredentials) Clone() credentials.TransportCredentials {
+	return timedCredentials{TransportCredentials: c.TransportCredentials.Clone(), j: c.j}
+}
+
+type timeoutError struct {
+	error
+}
+
+func (timeoutError) Timeout() bool {
+	return true
+}
+
+func (e timeoutError) Unwrap() error {
+	return e.error
+}
+
+// NewJobProducer creates gRPC load generator.
+func NewJobProducer(f Flags) (*JobProducer, error) {
+	if f.ConnectTimeout == 0 {
+		f.ConnectTimeout = 30 * time.Second
+	}
+
+	if f.Connections < 1 {
+		f.Connections = 1
+	}
+
+	j := &JobProducer{
+		f:   f,
+		ctx: context.Background(),
+
+		connHist:            &dynhist.Collector{BucketsLimit: 10, WeightFunc: dynhist.LatencyWidth},
+		tlsHist:             &dynhist.Collector{BucketsLimit: 10, WeightFunc: dynhist.LatencyWidth},
+		firstMsgHist:        &dynhist.Collector{BucketsLimit: 10, WeightFunc: dynhist.LatencyWidth},
+		firstMsgHistPrecise: &dynhist.Collector{BucketsLimit: 100, WeightFunc: dynhist.LatencyWidth},
+		messagesHist:        &dynhist.Collector{BucketsLimit: 10},
+
+		codes:   make(map[codes.Code]int),
+		samples: make(map[codes.Code]string),
+	}
+
+	if len(f.Metadata) > 0 {
+		j.ctx = metadata.NewOutgoingContext(j.ctx, metadata.New(f.Metadata))
+	}
+
+	netDialer := net.Dialer{Timeout: f.ConnectTimeout}
+
+	opts := []grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			start := time.Now()
+
+			conn, err := netDialer.DialContext(ctx, "tcp", addr)
+			if err != nil {
+				return nil, err
+			}
+
+			j.connHist.Add(1000 * time.Since(start).Seconds())
+			atomic.AddInt64(&j.connections, 1)
+
+			return countingConn{Conn: conn, j: j}, nil
+		}),
+	}
+
+	if f.Plaintext {
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	} else {
+		cfg := &tls.Config{InsecureSkipVerify: f.Insecure} //nolint:gosec // Explicitly requested.
+		opts = append(opts, grpc.WithTransportCredentials(timedCredentials{
+			TransportCredentials: credentials.NewTLS(cfg),
+			j:                    j,
+		}))
+	}
+
+	if f.Authority != "" {
+		opts = append(opts, grpc.WithAuthority(f.Authority))
+	}
+
+	for range f.Connections {
+		conn, err := grpc.NewClient(f.Target, opts...)
+		if err != nil {
+			j.Close()
+
+			return nil, fmt.Errorf("failed to create client: %w", err)
+		}
+
+		j.conns = append(j.conns, conn)
+	}
+
+	ctx, cancel := context.WithTimeout(j.ctx, f.ConnectTimeout)
+	defer cancel()
+
+	md, err := findMethod(ctx, f, j.conns[0])
+	if err != nil {
+		j.Close()
+
+		return nil, err
+	}
+
+	req := dynamicpb.NewMessage(md.Input())
+	if err := protojson.Unmarshal([]byte(f.Data), req); err != nil {
+		j.Close()
+
+		return nil, fmt.Errorf("failed to parse request data: %w", err)
+	}
+
+	j.md = md
+	j.req = req
+	j.method = "/" + string(md.Parent().FullName()) + "/" + string(md.Name())
+
+	return j, nil
+}
+
+// Close closes connections.
+func (j *JobProducer) Close() {
+	for _, conn := range j.conns {
+		_ = conn.Close()
+	}
+}
+
+// RequestCounts returns numbers of calls by status code.
+func (j *JobProducer) RequestCounts() map[string]int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	res := make(map[string]int, len(j.codes))
+	for code, cnt := range j.codes {
+		res[code.String()] = cnt
+	}
+
+	return res
+}
+
+// Job makes a call.
+func (j *JobProducer) Job(_ int) (time.Duration, error) {
+	conn := j.conns[atomic.AddUint64(&j.next, 1)%uint64(len(j.conns))]
+
+	ctx := j.ctx
+
+	if j.f.MaxTime > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, j.f.MaxTime)
+		defer cancel()
+	}
+
+	start := time.Now()
+
+	var (
+		resp proto.Message
+		err  error
+	)
+
+	if j.md.IsStreamingServer() {
+		resp, err = j.stream(ctx, conn, start)
+	} else {
+		resp = dynamicpb.NewMessage(j.md.Output())
+		err = conn.Invoke(ctx, j.method, j.req, resp)
+	}
+
+	elapsed := time.Since(start)
+
+	st, _ := status.FromError(err)
+	j.record(st, resp)
+
+	switch st.Code() { //nolint:exhaustive // Other codes are valid server responses.
+	case codes.DeadlineExceeded:
+		return 0, timeoutError{error: fmt.Errorf("%w: %w", context.DeadlineExceeded, err)}
+	case codes.Unavailable, codes.Canceled:
+		return 0, err
+	}
+
+	return elapsed, nil
+}
+
+// stream makes server streaming call and returns first received message.
+func (j *JobProducer) stream(ctx context.Context, conn *grpc.ClientConn, start time.Time) (proto.Message, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, j.method)
+	if err != nil {
+		return nil, err
+	}
+
+	// io.EOF on send means stream is terminated, actual status is received with RecvMsg.
+	if err := s.SendMsg(j.req); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	if err := s.CloseSend(); err != nil {
+		return nil, err
+	}
+
+	var (
+		first proto.Message
+		n     int
+	)
+
+	defer func() {
+		atomic.AddInt64(&j.streams, 1)
+		atomic.AddInt64(&j.messages, int64(n))
+		j.messagesHist.Add(float64(n))
+	}()
+
+	for {
+		m := dynamicpb.NewMessage(j.md.Output())
+
+		if err := s.RecvMsg(m); err != nil {
+			if errors.Is(err, io.EOF) {
+				return first, nil
+			}
+
+			return first, err
+		}
+
+		if n == 0 {
+			ms := 1000 * time.Since(start).Seconds()
+			j.firstMsgHist.Add(ms)
+			j.firstMsgHistPrecise.Add(ms)
+
+			first = m
+		}
+
+		n++
+	}
+}
+
+// record counts status code and keeps first response sample.
+func (j *JobProducer) record(st *status.Status, resp proto.Message) {
+	code := st.Code()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.codes[code]++
+
+	if _, ok := j.samples[code]; ok {
+		return
+	}
+
+	if code != codes.OK || resp == nil {
+		j.samples[code] = st.Message()
+
+		return
+	}
+
+	b, err := protojson.Marshal(resp)
+	if err != nil {
+		j.samples[code] = err.Error()
+
+		return
+	}
+
+	j.samples[code] = string(report.PeekBody(b, 1000))
+}
+
+// String renders report.
+func (j *JobProducer) String() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if len(j.codes) == 0 {
+		return ""
+	}
+
+	res := fmt.Sprintln("Connections:", atomic.LoadInt64(&j.connections))
+	res += fmt.Sprintln("Bytes read", report.ByteSize(atomic.LoadInt64(&j.bytesRead)), "total, written",
+		report.ByteSize(atomic.LoadInt64(&j.bytesWritten)), "total") + "\n"
+
+	cs := make([]codes.Code, 0, len(j.codes))
+	for code := range j.codes {
+		cs = append(cs, code)
+	}
+
+	sort.Slice(cs, func(i, k int) bool { return cs[i] < cs[k] })
+
+	res += "Responses by status code\n"
+
+	for _, code := range cs {
+		res += fmt.Sprintf("[%s] %d\n", code, j.codes[code])
+	}
+
+	res += "\n"
+
+	if j.connHist.Count > 0 {
+		res += "Connection latency distribution in ms:\n"
+		res += j.connHist.String() + "\n"
+	}
+
+	if j.tlsHist.Count > 0 {
+		res += "TLS handshake latency distribution in ms:\n"
+		res += j.tlsHist.String() + "\n"
+	}
+
+	if streams := atomic.LoadInt64(&j.streams); streams > 0 {
+		messages := atomic.LoadInt64(&j.messages)
+
+		res += fmt.Sprintf("Streams: %d, messages: %d, %.2f messages per stream\n\n",
+			streams, messages, float64(messages)/float64(streams))
+
+		if j.firstMsgHist.Count > 0 {
+			res += "Time to first message percentiles:\n"
+			res += fmt.Sprintf("99%%: %.2fms\n", j.firstMsgHistPrecise.Percentile(99))
+			res += fmt.Sprintf("95%%: %.2fms\n", j.firstMsgHistPrecise.Percentile(95))
+			res += fmt.Sprintf("90%%: %.2fms\n", j.firstMsgHistPrecise.Percentile(90))
+			res += fmt.Sprintf("50%%: %.2fms\n\n", j.firstMsgHistPrecise.Percentile(50))
+
+			res += "Time to first message distribution in ms:\n"
+			res += j.firstMsgHist.String() + "\n"
+		}
+
+		res += "Messages per stream distribution:\n"
+		res += j.messagesHist.String() + "\n"
+	}
+
+	res += "Response samples (first by status code):\n"
+
+	for _, code := range cs {
+		res += fmt.Sprintf("[%s]\n%s\n\n", code, j.samples[code])
+	}
+
+	return res
+}
@@ -0,0 +1,238 @@
+package grpc_test
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vearutop/plt/grpc"
+	"github.com/vearutop/plt/loadgen"
+	grpclib "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+type healthServer struct {
+	grpc_health_v1.UnimplementedHealthServer
+	t *testing.T
+}
+
+func (h healthServer) Check(
+	ctx context.Context, req *grpc_health_v1.HealthCheckRequest,
+) (*grpc_health_v1.HealthCheckResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	assert.Equal(h.t, []string{"bar"}, md.Get("x-foo"))
+
+	if req.GetService() == "missing" {
+		return nil, status.Error(codes.NotFound, "unknown service")
+	}
+
+	return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+}
+
+func (h healthServer) Watch(_ *grpc_health_v1.HealthCheckRequest, s grpc_health_v1.Health_WatchServer) error {
+	for range 3 {
+		if err := s.Send(&grpc_health_v1.HealthCheckResponse{
+			Status: grpc_health_v1.HealthCheckResponse_SERVING,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func startServer(t *testing.T, withReflection bool) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := grpclib.NewServer()
+	grpc_health_v1.RegisterHealthServer(srv, healthServer{t: t})
+
+	if withReflection {
+		reflection.Register(srv)
+	}
+
+	go func() {
+		_ = srv.Serve(l)
+	}()
+
+	t.Cleanup(srv.Stop)
+
+	return l.Addr().String()
+}
+
+func TestNewJobProducer(t *testing.T) {
+	out := bytes.NewBuffer(nil)
+
+	lf := loadgen.Flags{
+		Number:       10,
+		Concurrency:  2,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       out,
+	}
+	f := grpc.Flags{
+		Target:      startServer(t, true),
+		Method:      "grpc.health.v1.Health/Check",
+		Data:        `{"service":"foo"}`,
+		Metadata:    map[string]string{"x-foo": "bar"},
+		Plaintext:   true,
+		Connections: 2,
+	}
+
+	j, err := grpc.NewJobProducer(f)
+	require.NoError(t, err)
+
+	defer j.Close()
+
+	require.NoError(t, loadgen.Run(lf, j))
+
+	res := out.String()
+	assert.Contains(t, res, "Connections: 2\n")
+	assert.Contains(t, res, "Responses by status code\n[OK] 10\n")
+	assert.Contains(t, res, "Connection latency distribution in ms:\n")
+	assert.Contains(t, res, "[OK]\n{\"status\":\"SERVING\"}\n")
+	assert.Equal(t, map[string]int{"OK": 10}, j.RequestCounts())
+
+	f.Data = `{"service":"missing"}`
+
+	j, err = grpc.NewJobProducer(f)
+	require.NoError(t, err)
+
+	defer j.Close()
+
+	_, err = j.Job(0)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"NotFound": 1}, j.RequestCounts())
+	assert.Contains(t, j.String(), "[NotFound]\nunknown service\n")
+
+	f.Data = `{"unknown":1}`
+	_, err = grpc.NewJobProducer(f)
+	require.Error(t, err)
+}
+
+func TestNewJobProducer_stream(t *testing.T) {
+	out := bytes.NewBuffer(nil)
+
+	lf := loadgen.Flags{
+		Number:       5,
+		Concurrency:  1,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       out,
+	}
+	f := grpc.Flags{
+		Target:    startServer(t, true),
+		Method:    "/grpc.health.v1.Health/Watch",
+		Data:      "{}",
+		Plaintext: true,
+	}
+
+	j, err := grpc.NewJobProducer(f)
+	require.NoError(t, err)
+
+	defer j.Close()
+
+	require.NoError(t, loadgen.Run(lf, j))
+
+	res := out.String()
+	assert.Contains(t, res, "Streams: 5, messages: 15, 3.00 messages per stream\n")
+	assert.Contains(t, res, "Time to first message distribution in ms:\n")
+	assert.Regexp(t, `Messages per stream distribution:\n.+ \(5 events\)\n`, res)
+	assert.Equal(t, map[string]int{"OK": 5}, j.RequestCounts())
+}
+
+func TestNewJobProducer_protoset(t *testing.T) {
+	fds := &descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{
+			protodesc.ToFileDescriptorProto(grpc_health_v1.File_grpc_health_v1_health_proto),
+		},
+	}
+
+	b, err := proto.Marshal(fds)
+	require.NoError(t, err)
+
+	fn := filepath.Join(t.TempDir(), "health.protoset")
+	require.NoError(t, os.WriteFile(fn, b, 0o600))
+
+	f := grpc.Flags{
+		Target:    startServer(t, false),
+		Method:    "grpc.health.v1.Health.Check",
+		Data:      "{}",
+		Metadata:  map[string]string{"x-foo": "bar"},
+		Protoset:  []string{fn},
+		Plaintext: true,
+	}
+
+	j, err := grpc.NewJobProducer(f)
+	require.NoError(t, err)
+
+	defer j.Close()
+
+	_, err = j.Job(0)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"OK": 1}, j.RequestCounts())
+
+	f.Method = "grpc.health.v1.Health/Unknown"
+	_, err = grpc.NewJobProducer(f)
+	require.EqualError(t, err, "failed to find method Unknown in service grpc.health.v1.Health")
+}
+
+func TestNewJobProducer_proto(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "health.proto"), []byte(`syntax = "proto3";
+
+package grpc.health.v1;
+
+message HealthCheckRequest {
+  string service = 1;
+}
+
+message HealthCheckResponse {
+  enum ServingStatus {
+    UNKNOWN = 0;
+    SERVING = 1;
+    NOT_SERVING = 2;
+  }
+  ServingStatus status = 1;
+}
+
+service Health {
+  rpc Check(HealthCheckRequest) returns (HealthCheckResponse);
+}
+`), 0o600))
+
+	f := grpc.Flags{
+		Target:     startServer(t, false),
+		Method:     "grpc.health.v1.Health/Check",
+		Data:       `{"service":"missing"}`,
+		Metadata:   map[string]string{"x-foo": "bar"},
+		Proto:      []string{"health.proto"},
+		ImportPath: []string{dir},
+		Plaintext:  true,
+	}
+
+	j, err := grpc.NewJobProducer(f)
+	require.NoError(t, err)
+
+	defer j.Close()
+
+	_, err = j.Job(0)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"NotFound": 1}, j.RequestCounts())
+}
@@ -52,3 +52,42 @@ func TestNewJobProducer(t *testing.T) {
 
 	assert.Equal(t, map[string]int{"200": 100}, j.RequestCounts())
 }
+
+func TestRun_timeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("slow") != "" {
+			time.Sleep(200 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	out := bytes.NewBuffer(nil)
+
+	lf := loadgen.Flags{
+		Number:       10,
+		Concurrency:  5,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       out,
+	}
+	f := nethttp.Flags{
+		HeaderMap: map[string]string{},
+		URL:       srv.URL,
+		Method:    http.MethodGet,
+		MaxTime:   50 * time.Millisecond,
+	}
+	j, err := nethttp.NewJobProducer(f, lf)
+	require.NoError(t, err)
+
+	j.PrepareRequest = func(i int, req *http.Request) error {
+		if i%2 == 0 {
+			req.URL.RawQuery = "slow=1"
+		}
+
+		return nil
+	}
+
+	require.NoError(t, loadgen.Run(lf, j))
+	assert.Contains(t, out.String(), "Failed requests: 5")
+	assert.Contains(t, out.String(), "Timed out requests: 5")
+}
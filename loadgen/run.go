@@ -3,6 +3,7 @@ package loadgen
 import (
 	"bytes"
 	"context"
+	"errors"
 	"expvar"
 	"fmt"
 	"math"
@@ -31,6 +32,7 @@ type runner struct {
 	rateLimit        int64
 	currentReqRate   int64
 	errCnt           int64
+	timeoutCnt       int64
 
 	n   int
 	dur time.Duration
@@ -90,6 +92,10 @@ func Run(lf Flags, jobProducer JobProducer) error {
 
 				atomic.AddInt64(&r.errCnt, 1)
 
+				if isTimeout(err) {
+					atomic.AddInt64(&r.timeoutCnt, 1)
+				}
+
 				return
 			}
 
@@ -203,11 +209,22 @@ func (r *runner) report() error {
 		e := r.lastErr.Error()
 		cnt := r.errCnt
 		_, _ = fmt.Fprintf(lf.Output, "Failed requests: %d, last error: %s\n", cnt, e)
+
+		if tc := atomic.LoadInt64(&r.timeoutCnt); tc > 0 {
+			_, _ = fmt.Fprintf(lf.Output, "Timed out requests: %d\n", tc)
+		}
 	}
 
 	_, _ = fmt.Fprintln(lf.Output, "Time spent:", time.Since(r.start).Round(time.Millisecond))
 
 	if r.roundTripHist.Count == 0 {
+		// Producer may still have details of failures, e.g. trace IDs.
+		if s, ok := r.jobProducer.(fmt.Stringer); ok {
+			if res := s.String(); res != "" {
+				_, _ = fmt.Fprintln(lf.Output, "\n"+res)
+			}
+		}
+
 		return fmt.Errorf("all requests failed: %w", r.lastErr)
 	}
 
@@ -230,6 +247,17 @@ func (r *runner) report() error {
 	return nil
 }
 
+// isTimeout checks if error is caused by a timeout.
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var te interface{ Timeout() bool }
+
+	return errors.As(err, &te) && te.Timeout()
+}
+
 func (r *runner) captureLiveUI() {
 	lf := r.lf
 
@@ -427,6 +455,10 @@ func (r *runner) runLiveUI() {
 			counts["err"] = int(errCnt)
 		}
 
+		if timeoutCnt := atomic.LoadInt64(&r.timeoutCnt); timeoutCnt != 0 {
+			counts["timeout"] = int(timeoutCnt)
+		}
+
 		requestCounters := widgets.NewParagraph()
 		requestCounters.Title = " Request Count "
 		requestCounters.Text = ""
@@ -0,0 +1,441 @@
+package nethttp
+
+import (
+	"bytes"
+	"crypto/md5" //nolint:gosec // MD5 is a part of Digest authentication scheme.
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+)
+
+const maxAuthAttempts = 3 // Number of challenges to handle per request.
+
+// authenticator keeps credentials and challenges cached per connection.
+type authenticator struct {
+	user string
+	pass string
+
+	digest  bool
+	anyAuth bool
+
+	mu    sync.Mutex
+	basic bool // Basic scheme negotiated with anyauth.
+
+	// Digest challenges by connection, nil key is used by transports that do not trace connections (HTTP/3).
+	// Entries are removed when connection is closed.
+	challenges map[*authConn][]*digestChallenge
+
+	signer  *v4.Signer
+	region  string
+	service string
+}
+
+// digestChallenge is a server nonce with a count of requests made with it.
+//
+// Challenge is leased by one request at a time, so that requests reach server with increasing nonce counts.
+type digestChallenge struct {
+	params map[string]string
+	nc     int
+	leased bool
+}
+
+// authConn is a dialed connection that drops its digest challenges when closed.
+type authConn struct {
+	net.Conn
+	a      *authenticator
+	closed bool // Guarded by authenticator mutex.
+}
+
+// Close closes the connection.
+func (c *authConn) Close() error {
+	c.a.mu.Lock()
+	c.closed = true
+	delete(c.a.challenges, c)
+	c.a.mu.Unlock()
+
+	return c.Conn.Close()
+}
+
+// wrapConn binds connection to its digest challenges.
+func (t *authenticator) wrapConn(c net.Conn) net.Conn {
+	return &authConn{Conn: c, a: t}
+}
+
+// authConnOf returns authenticated connection used by transport or nil.
+func authConnOf(c net.Conn) *authConn {
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+
+	ac, _ := c.(*authConn)
+
+	return ac
+}
+
+// authTransport authenticates requests with Digest, Basic (when negotiated with anyauth) or AWS SigV4.
+type authTransport struct {
+	next http.RoundTripper
+	a    *authenticator
+}
+
+func newAuthenticator(f Flags) (*authenticator, error) {
+	if !f.AuthDigest && !f.AuthAny && f.AWSSigV4 == "" {
+		return nil, nil //nolint:nilnil // Nil value disables authentication.
+	}
+
+	user, pass, found := strings.Cut(f.User, ":")
+	if !found {
+		return nil, errors.New("user must be in form user:pass")
+	}
+
+	t := &authenticator{
+		user:       user,
+		pass:       pass,
+		digest:     f.AuthDigest,
+		anyAuth:    f.AuthAny,
+		challenges: make(map[*authConn][]*digestChallenge),
+	}
+
+	if f.AWSSigV4 != "" {
+		if err := t.setupSigV4(f); err != nil {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+// setupSigV4 parses curl-style "provider1[:provider2[:region[:service]]]" parameter.
+func (t *authenticator) setupSigV4(f Flags) error {
+	parts := strings.Split(f.AWSSigV4, ":")
+	if len(parts) > 2 {
+		t.region = parts[2]
+	}
+
+	if len(parts) > 3 {
+		t.service = parts[3]
+	}
+
+	if t.region == "" || t.service == "" {
+		// Infer from host name, e.g. service.region.amazonaws.com.
+		host := f.URL
+		if i := strings.Index(host, "://"); i >= 0 {
+			host = host[i+3:]
+		}
+
+		host, _, _ = strings.Cut(host, "/")
+		labels := strings.Split(host, ".")
+
+		if len(labels) < 4 {
+			return fmt.Errorf("can not infer AWS region and service from host %q, use provider1:provider2:region:service", host)
+		}
+
+		if t.service == "" {
+			t.service = labels[0]
+		}
+
+		if t.region == "" {
+			t.region = labels[1]
+		}
+	}
+
+	t.signer = v4.NewSigner(credentials.NewStaticCredentials(t.user, t.pass, ""))
+
+	return nil
+}
+
+// RoundTrip executes a single HTTP transaction.
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.a.signer != nil {
+		return t.sign(req)
+	}
+
+	var resp *http.Response
+
+	// Retried request may get another connection without cached challenge.
+	for range maxAuthAttempts {
+		r, c, err := t.a.authorize(req)
+		if err != nil {
+			return nil, err
+		}
+
+		var conn *authConn
+
+		trace := &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				conn = authConnOf(info.Conn)
+			},
+		}
+
+		resp, err = t.next.RoundTrip(r.WithContext(httptrace.WithClientTrace(r.Context(), trace)))
+
+		t.a.release(c)
+
+		if err != nil || resp.StatusCode != http.StatusUnauthorized {
+			return resp, err
+		}
+
+		if !t.a.updateChallenge(conn, c, resp.Header.Values("WWW-Authenticate")) {
+			return resp, nil
+		}
+
+		// Body of request can not be sent again.
+		if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+			return resp, nil
+		}
+
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}
+
+	r, c, err := t.a.authorize(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer t.a.release(c)
+
+	return t.next.RoundTrip(r)
+}
+
+func (t *authTransport) sign(req *http.Request) (*http.Response, error) {
+	var body []byte
+
+	if req.GetBody != nil {
+		rc, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+
+		if body, err = io.ReadAll(rc); err != nil {
+			return nil, err
+		}
+	}
+
+	r := req.Clone(req.Context())
+
+	if _, err := t.a.signer.Sign(r, bytes.NewReader(body), t.a.service, t.a.region, time.Now()); err != nil {
+		return nil, fmt.Errorf("failed to sign request: %w", err)
+	}
+
+	return t.next.RoundTrip(r)
+}
+
+// authorize adds Authorization header with a negotiated scheme, leased digest challenge is returned to be released
+// after response.
+//
+// Digest challenge is not shared by concurrent requests, so that requests reach server with increasing nonce counts.
+// Request without idle challenge is sent as is to receive a new challenge.
+func (t *authenticator) authorize(req *http.Request) (*http.Request, *digestChallenge, error) {
+	r := req.Clone(req.Context())
+
+	if req.GetBody != nil && req.Body != nil && req.Body != http.NoBody {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		r.Body = body
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.basic {
+		r.SetBasicAuth(t.user, t.pass)
+
+		return r, nil, nil
+	}
+
+	for _, cc := range t.challenges {
+		for _, c := range cc {
+			if c.leased {
+				continue
+			}
+
+			c.leased = true
+			c.nc++
+
+			r.Header.Set("Authorization", t.digestHeader(c.params, r.Method, r.URL.RequestURI(), c.nc))
+
+			return r, c, nil
+		}
+	}
+
+	return r, nil, nil
+}
+
+// release makes digest challenge available to next requests.
+func (t *authenticator) release(c *digestChallenge) {
+	if c == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c.leased = false
+}
+
+// updateChallenge caches authentication scheme and nonce of connection from server challenge,
+// used is a challenge that was rejected or nil.
+func (t *authenticator) updateChallenge(conn *authConn, used *digestChallenge, challenges []string) bool {
+	var basic, digest map[string]string
+
+	for _, c := range challenges {
+		scheme, params, _ := strings.Cut(c, " ")
+
+		switch strings.ToLower(scheme) {
+		case "digest":
+			digest = parseAuthParams(params)
+		case "basic":
+			basic = map[string]string{}
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch {
+	case digest != nil && (t.digest || t.anyAuth):
+		if used != nil {
+			// Same nonce rejected and not stale means wrong credentials.
+			if digest["nonce"] == used.params["nonce"] && !strings.EqualFold(digest["stale"], "true") {
+				return false
+			}
+
+			used.params = digest
+			used.nc = 0
+
+			return true
+		}
+
+		// Closed connection does not receive new challenges.
+		if conn != nil && conn.closed {
+			return true
+		}
+
+		t.challenges[conn] = append(t.challenges[conn], &digestChallenge{params: digest})
+
+		return true
+	case basic != nil && t.anyAuth && len(t.challenges) == 0 && !t.basic:
+		t.basic = true
+
+		return true
+	}
+
+	return false
+}
+
+// digestHeader builds RFC 7616 Authorization header value.
+func (t *authenticator) digestHeader(c map[string]string, method, uri string, nc int) string {
+	var h func() hash.Hash
+
+	algorithm := c["algorithm"]
+
+	switch strings.TrimSuffix(strings.ToUpper(algorithm), "-SESS") {
+	case "SHA-256":
+		h = sha256.New
+	default:
+		h = md5.New
+	}
+
+	hexHash := func(s string) string {
+		hh := h()
+		_, _ = hh.Write([]byte(s))
+
+		return hex.EncodeToString(hh.Sum(nil))
+	}
+
+	cnonceBytes := make([]byte, 8)
+	_, _ = rand.Read(cnonceBytes)
+	cnonce := base64.RawURLEncoding.EncodeToString(cnonceBytes)
+	ncs := fmt.Sprintf("%08x", nc)
+
+	ha1 := hexHash(t.user + ":" + c["realm"] + ":" + t.pass)
+	if strings.HasSuffix(strings.ToUpper(algorithm), "-SESS") {
+		ha1 = hexHash(ha1 + ":" + c["nonce"] + ":" + cnonce)
+	}
+
+	ha2 := hexHash(method + ":" + uri)
+
+	qop := ""
+
+	for _, q := range strings.Split(c["qop"], ",") {
+		if strings.TrimSpace(q) == "auth" {
+			qop = "auth"
+		}
+	}
+
+	var response string
+	if qop != "" {
+		response = hexHash(ha1 + ":" + c["nonce"] + ":" + ncs + ":" + cnonce + ":" + qop + ":" + ha2)
+	} else {
+		response = hexHash(ha1 + ":" + c["nonce"] + ":" + ha2)
+	}
+
+	res := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", response="%s"`,
+		t.user, c["realm"], c["nonce"], uri, response)
+
+	if algorithm != "" {
+		res += ", algorithm=" + algorithm
+	}
+
+	if c["opaque"] != "" {
+		res += fmt.Sprintf(`, opaque="%s"`, c["opaque"])
+	}
+
+	if qop != "" {
+		res += fmt.Sprintf(`, qop=%s, nc=%s, cnonce="%s"`, qop, ncs, cnonce)
+	}
+
+	return res
+}
+
+// parseAuthParams parses comma-separated key=value pairs with optionally quoted values.
+func parseAuthParams(s string) map[string]string {
+	res := make(map[string]string)
+
+	for s != "" {
+		s = strings.TrimLeft(s, ", ")
+
+		k, rest, found := strings.Cut(s, "=")
+		if !found {
+			break
+		}
+
+		k = strings.ToLower(strings.TrimSpace(k))
+
+		var v string
+
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				v, s = rest[1:], ""
+			} else {
+				v, s = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			v, s, _ = strings.Cut(rest, ",")
+		}
+
+		res[k] = strings.TrimSpace(v)
+	}
+
+	return res
+}
@@ -0,0 +1,202 @@
+package nethttp
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/vearutop/plt/report"
+)
+
+// CompressionStats aggregates wire and decoded sizes of compressed responses by content encoding.
+type CompressionStats struct {
+	mu         sync.Mutex
+	byEncoding map[string]*encodingStats
+}
+
+type encodingStats struct {
+	count      int64
+	wire       int64
+	decoded    int64
+	decodeTime time.Duration
+}
+
+// Add registers decoded response.
+func (s *CompressionStats) Add(encoding string, wire, decoded int64, decodeTime time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.byEncoding == nil {
+		s.byEncoding = make(map[string]*encodingStats)
+	}
+
+	es := s.byEncoding[encoding]
+	if es == nil {
+		es = &encodingStats{}
+		s.byEncoding[encoding] = es
+	}
+
+	es.count++
+	es.wire += wire
+	es.decoded += decoded
+	es.decodeTime += decodeTime
+}
+
+// String renders compression report.
+func (s *CompressionStats) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.byEncoding) == 0 {
+		return ""
+	}
+
+	encodings := make([]string, 0, len(s.byEncoding))
+	for e := range s.byEncoding {
+		encodings = append(encodings, e)
+	}
+
+	sort.Strings(encodings)
+
+	res := "Compressed responses by content encoding:\n"
+
+	for _, e := range encodings {
+		es := s.byEncoding[e]
+
+		ratio := 0.0
+		if es.wire > 0 {
+			ratio = float64(es.decoded) / float64(es.wire)
+		}
+
+		res += fmt.Sprintf("[%s] %d, wire %s (%s avg), decoded %s (%s avg), ratio %.2f, decode time %.3fms avg\n",
+			e, es.count,
+			report.ByteSize(es.wire), report.ByteSize(es.wire/es.count),
+			report.ByteSize(es.decoded), report.ByteSize(es.decoded/es.count),
+			ratio, 1000*es.decodeTime.Seconds()/float64(es.count))
+	}
+
+	return res
+}
+
+// DecodingSupported checks if content encoding can be decoded.
+func DecodingSupported(encoding string) bool {
+	switch strings.ToLower(encoding) {
+	case "gzip", "x-gzip", "deflate", "br", "zstd":
+		return true
+	}
+
+	return false
+}
+
+// timedReader counts bytes and time spent in reads.
+type timedReader struct {
+	r       io.Reader
+	n       int64
+	elapsed time.Duration
+}
+
+func (t *timedReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := t.r.Read(p)
+	t.elapsed += time.Since(start)
+	t.n += int64(n)
+
+	return n, err
+}
+
+// decodingReader decodes compressed body and measures wire and decoded sizes.
+type decodingReader struct {
+	encoding string
+	wire     *timedReader
+	decoded  *timedReader
+	closer   func()
+}
+
+func newDecodingReader(encoding string, body io.Reader) *decodingReader {
+	d := &decodingReader{
+		encoding: strings.ToLower(encoding),
+		wire:     &timedReader{r: body},
+	}
+
+	d.decoded = &timedReader{r: readerFunc(d.init)}
+
+	return d
+}
+
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) {
+	return f(p)
+}
+
+// init creates decoder lazily so that reading of encoding header is timed.
+func (d *decodingReader) init(p []byte) (int, error) {
+	var (
+		r   io.Reader
+		err error
+	)
+
+	switch d.encoding {
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(d.wire)
+	case "deflate":
+		// Deflate is expected to be zlib-wrapped, but raw stream is also used in the wild.
+		br := bufio.NewReader(d.wire)
+
+		h, _ := br.Peek(2)
+		if len(h) == 2 && h[0]&0x0f == 8 && (uint16(h[0])<<8|uint16(h[1]))%31 == 0 {
+			r, err = zlib.NewReader(br)
+		} else {
+			r = flate.NewReader(br)
+		}
+	case "br":
+		r = brotli.NewReader(d.wire)
+	case "zstd":
+		var zr *zstd.Decoder
+
+		zr, err = zstd.NewReader(d.wire, zstd.WithDecoderConcurrency(1))
+		if err == nil {
+			d.closer = zr.Close
+			r = zr
+		}
+	default:
+		err = fmt.Errorf("unsupported content encoding: %s", d.encoding)
+	}
+
+	if err == io.EOF { //nolint:errorlint // EOF is not wrapped by decoders.
+		// Empty body.
+		return 0, io.EOF
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to init %s decoder: %w", d.encoding, err)
+	}
+
+	d.decoded.r = r
+
+	return r.Read(p)
+}
+
+func (d *decodingReader) Read(p []byte) (int, error) {
+	return d.decoded.Read(p)
+}
+
+// decodeTime returns time spent on decoding, excluding waiting for data.
+func (d *decodingReader) decodeTime() time.Duration {
+	return d.decoded.elapsed - d.wire.elapsed
+}
+
+func (d *decodingReader) close() {
+	if d.closer != nil {
+		d.closer()
+	}
+}
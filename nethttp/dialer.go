@@ -0,0 +1,369 @@
+package nethttp
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// Dialer establishes client connections according to Flags.
+//
+// It is shared by net/http and fasthttp producers to keep connection setup consistent.
+type Dialer struct {
+	// OnProxyConnect is called with time spent to establish a tunnel through proxy.
+	OnProxyConnect func(elapsed time.Duration)
+
+	d          *net.Dialer
+	proxy      *url.URL
+	socks      proxy.ContextDialer
+	unixSocket string
+	local      *localAddrs
+	limitRate  int64
+	throughput Throughput
+
+	connectTimeout time.Duration
+}
+
+// NewDialer creates connection dialer.
+func NewDialer(f Flags) (*Dialer, error) {
+	d := &Dialer{
+		d: &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		},
+		connectTimeout: f.ConnectTimeout,
+	}
+
+	if f.ConnectTimeout > 0 {
+		d.d.Timeout = f.ConnectTimeout
+	}
+
+	if f.UnixSocket != "" {
+		if f.Proxy != "" {
+			return nil, errors.New("proxy can not be used with unix socket")
+		}
+
+		d.unixSocket = f.UnixSocket
+	}
+
+	local, err := newLocalAddrs(f)
+	if err != nil {
+		return nil, err
+	}
+
+	d.local = local
+	d.limitRate = f.LimitRate
+
+	if f.Proxy == "" {
+		return d, nil
+	}
+
+	u, err := parseProxyURL(f.Proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	d.proxy = u
+
+	switch u.Scheme {
+	case "http", "https":
+	case "socks5", "socks5h":
+		pd, err := proxy.FromURL(u, direct{d: d})
+		if err != nil {
+			return nil, fmt.Errorf("failed to init SOCKS5 proxy: %w", err)
+		}
+
+		cd, ok := pd.(proxy.ContextDialer)
+		if !ok {
+			return nil, errors.New("SOCKS5 proxy dialer does not support context")
+		}
+
+		d.socks = cd
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme: %s", u.Scheme)
+	}
+
+	return d, nil
+}
+
+func parseProxyURL(s string) (*url.URL, error) {
+	if !strings.Contains(s, "://") {
+		s = "http://" + s
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse proxy URL: %w", err)
+	}
+
+	if u.Port() == "" {
+		port := "1080"
+
+		switch u.Scheme {
+		case "http":
+			port = "80"
+		case "https":
+			port = "443"
+		}
+
+		u.Host = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	return u, nil
+}
+
+// Proxy returns configured proxy URL or nil.
+func (d *Dialer) Proxy() *url.URL {
+	return d.proxy
+}
+
+// UnixSocket returns configured unix socket path or empty string.
+func (d *Dialer) UnixSocket() string {
+	return d.unixSocket
+}
+
+// SourceAddr returns description of configured local addresses or empty string.
+func (d *Dialer) SourceAddr() string {
+	if d.local == nil {
+		return ""
+	}
+
+	return d.local.String()
+}
+
+// LimitRate returns configured connection speed limit in bytes per second, 0 means no limit.
+func (d *Dialer) LimitRate() int64 {
+	return d.limitRate
+}
+
+// Throughput returns effective throughput of throttled connections.
+func (d *Dialer) Throughput() *Throughput {
+	return &d.throughput
+}
+
+// Custom tells if dialer has settings beyond default direct connection.
+func (d *Dialer) Custom() bool {
+	return d.proxy != nil || d.unixSocket != "" || d.local != nil || d.limitRate > 0 || d.connectTimeout > 0
+}
+
+// DialContext connects to the address on the named network.
+//
+// If unix socket is configured, it is used instead of network address.
+// If proxy is configured, connection is tunneled with HTTP CONNECT or SOCKS5.
+// If rate limit is configured, connection speed is throttled.
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if d.connectTimeout > 0 {
+		// Connect timeout also covers proxy handshake.
+		var cancel func()
+
+		ctx, cancel = context.WithTimeout(ctx, d.connectTimeout)
+		defer cancel()
+	}
+
+	c, err := d.dial(ctx, network, addr)
+	if err != nil || d.limitRate <= 0 {
+		return c, err
+	}
+
+	return ThrottleConn(c, d.limitRate, &d.throughput), nil
+}
+
+func (d *Dialer) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	if d.unixSocket != "" {
+		return d.d.DialContext(ctx, "unix", d.unixSocket)
+	}
+
+	if d.proxy == nil {
+		return d.dialDirect(ctx, network, addr)
+	}
+
+	start := time.Now()
+
+	var (
+		c   net.Conn
+		err error
+	)
+
+	if d.socks != nil {
+		c, err = d.dialSocks(ctx, network, addr)
+	} else {
+		c, err = d.dialConnect(ctx, network, addr)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("proxy %s: %w", d.proxy.Redacted(), err)
+	}
+
+	if d.OnProxyConnect != nil {
+		d.OnProxyConnect(time.Since(start))
+	}
+
+	return c, nil
+}
+
+// dialConnect opens a tunnel with HTTP CONNECT method.
+func (d *Dialer) dialConnect(ctx context.Context, network, addr string) (net.Conn, error) {
+	c, err := d.dialDirect(ctx, network, d.proxy.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.proxy.Scheme == "https" {
+		tc := tls.Client(c, &tls.Config{ServerName: d.proxy.Hostname()}) //nolint:gosec // Default min version.
+		if err := tc.HandshakeContext(ctx); err != nil {
+			_ = c.Close()
+
+			return nil, err
+		}
+
+		c = tc
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+
+	if u := d.proxy.User; u != nil {
+		p, _ := u.Password()
+		req.Header.Set("Proxy-Authorization",
+			"Basic "+base64.StdEncoding.EncodeToString([]byte(u.Username()+":"+p)))
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = c.SetDeadline(deadline)
+
+		defer func() {
+			_ = c.SetDeadline(time.Time{})
+		}()
+	}
+
+	if err := req.Write(c); err != nil {
+		_ = c.Close()
+
+		return nil, err
+	}
+
+	br := bufio.NewReader(c)
+
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		_ = c.Close()
+
+		return nil, err
+	}
+
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		_ = c.Close()
+
+		return nil, fmt.Errorf("unexpected CONNECT response status: %s", resp.Status)
+	}
+
+	// Proxy may send tunneled bytes together with response.
+	if br.Buffered() > 0 {
+		return bufferedConn{Conn: c, r: br}, nil
+	}
+
+	return c, nil
+}
+
+// bufferedConn reads buffered bytes before reading from the connection.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// Read reads data from the connection.
+func (c bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// dialSocks opens a tunnel with SOCKS5 proxy.
+//
+// With socks5 scheme target host is resolved locally (as with curl --socks5),
+// socks5h sends host name to proxy.
+func (d *Dialer) dialSocks(ctx context.Context, network, addr string) (net.Conn, error) {
+	if d.proxy.Scheme == "socks5" {
+		var err error
+
+		if addr, err = resolveAddr(ctx, network, addr); err != nil {
+			return nil, err
+		}
+	}
+
+	return d.socks.DialContext(ctx, network, addr)
+}
+
+// resolveAddr replaces host name of the address with its IP, IPv4 is preferred if network allows both families.
+func resolveAddr(ctx context.Context, network, addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+
+	if net.ParseIP(host) != nil {
+		return addr, nil
+	}
+
+	ipNet := "ip"
+
+	switch {
+	case strings.HasSuffix(network, "4"):
+		ipNet = "ip4"
+	case strings.HasSuffix(network, "6"):
+		ipNet = "ip6"
+	}
+
+	ips, err := net.DefaultResolver.LookupIP(ctx, ipNet, host)
+	if err != nil {
+		return "", err
+	}
+
+	ip := ips[0]
+
+	for _, i := range ips {
+		if i.To4() != nil {
+			ip = i
+
+			break
+		}
+	}
+
+	return net.JoinHostPort(ip.String(), port), nil
+}
+
+// dialDirect connects to the address without proxy, but with local address binding.
+func (d *Dialer) dialDirect(ctx context.Context, network, addr string) (net.Conn, error) {
+	if d.local != nil {
+		return d.local.dial(ctx, d.d, network, addr)
+	}
+
+	return d.d.DialContext(ctx, network, addr)
+}
+
+// direct is a forward dialer for SOCKS5 proxy.
+type direct struct {
+	d *Dialer
+}
+
+func (d direct) Dial(network, addr string) (net.Conn, error) {
+	return d.d.dialDirect(context.Background(), network, addr)
+}
+
+func (d direct) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d.d.dialDirect(ctx, network, addr)
+}
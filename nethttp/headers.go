@@ -0,0 +1,150 @@
+package nethttp
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/vearutop/dynhist-go"
+)
+
+// maxHeaderValues limits number of distinct values kept per collected header.
+const maxHeaderValues = 100
+
+// HeaderStats aggregates values of selected response headers.
+type HeaderStats struct {
+	mu      sync.Mutex
+	values  map[string]map[string]int
+	latency map[string]*headerLatency
+}
+
+type headerLatency struct {
+	scale   float64 // Multiplier to milliseconds.
+	hist    *dynhist.Collector
+	precise *dynhist.Collector
+}
+
+// NewHeaderStats creates header collector from CollectHeader and CollectHeaderLatency flags.
+func NewHeaderStats(f Flags) (*HeaderStats, error) {
+	s := &HeaderStats{
+		values:  make(map[string]map[string]int, len(f.CollectHeader)),
+		latency: make(map[string]*headerLatency, len(f.CollectHeaderLatency)),
+	}
+
+	for _, h := range f.CollectHeader {
+		s.values[http.CanonicalHeaderKey(h)] = make(map[string]int)
+	}
+
+	for _, h := range f.CollectHeaderLatency {
+		name, unit, _ := strings.Cut(h, ":")
+
+		hl := &headerLatency{
+			hist:    &dynhist.Collector{BucketsLimit: 10, WeightFunc: dynhist.LatencyWidth},
+			precise: &dynhist.Collector{BucketsLimit: 100, WeightFunc: dynhist.LatencyWidth},
+		}
+
+		switch unit {
+		case "", "ms":
+			hl.scale = 1
+		case "s":
+			hl.scale = 1000
+		case "us":
+			hl.scale = 0.001
+		default:
+			return nil, fmt.Errorf("unsupported unit %q of header %s, use ms, s or us", unit, name)
+		}
+
+		s.latency[http.CanonicalHeaderKey(name)] = hl
+	}
+
+	return s, nil
+}
+
+// Empty is true if no headers are collected.
+func (s *HeaderStats) Empty() bool {
+	return len(s.values) == 0 && len(s.latency) == 0
+}
+
+// Add collects values of response headers with a lookup function.
+func (s *HeaderStats) Add(get func(name string) string) {
+	for name, hl := range s.latency {
+		v, err := strconv.ParseFloat(strings.TrimSpace(get(name)), 64)
+		if err == nil {
+			hl.hist.Add(v * hl.scale)
+			hl.precise.Add(v * hl.scale)
+		}
+	}
+
+	if len(s.values) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name, values := range s.values {
+		v := get(name)
+
+		if _, ok := values[v]; !ok && len(values) >= maxHeaderValues {
+			v = "<other>"
+		}
+
+		values[v]++
+	}
+}
+
+// String renders collected headers report.
+func (s *HeaderStats) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := ""
+
+	for _, name := range sortedKeys(s.values) {
+		values := s.values[name]
+
+		res += "Responses by " + name + " header value:\n"
+
+		for _, v := range sortedKeys(values) {
+			if v == "" {
+				res += fmt.Sprintf("[<none>] %d\n", values[v])
+			} else {
+				res += fmt.Sprintf("[%s] %d\n", v, values[v])
+			}
+		}
+
+		res += "\n"
+	}
+
+	for _, name := range sortedKeys(s.latency) {
+		hl := s.latency[name]
+		if hl.hist.Count == 0 {
+			continue
+		}
+
+		res += name + " latency percentiles:\n"
+		res += fmt.Sprintf("99%%: %.2fms\n", hl.precise.Percentile(99))
+		res += fmt.Sprintf("95%%: %.2fms\n", hl.precise.Percentile(95))
+		res += fmt.Sprintf("90%%: %.2fms\n", hl.precise.Percentile(90))
+		res += fmt.Sprintf("50%%: %.2fms\n\n", hl.precise.Percentile(50))
+
+		res += name + " latency distribution in ms:\n"
+		res += hl.hist.String() + "\n"
+	}
+
+	return res
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
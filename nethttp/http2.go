@@ -0,0 +1,391 @@
+package nethttp
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vearutop/dynhist-go"
+	"golang.org/x/net/http2"
+)
+
+// HTTP/2 frame types and settings of interest, see RFC 9113.
+const (
+	h2FrameHeaders      = 0x1
+	h2FrameRSTStream    = 0x3
+	h2FrameSettings     = 0x4
+	h2FrameGoAway       = 0x7
+	h2FrameWindowUpdate = 0x8
+
+	h2SettingMaxConcurrentStreams = 0x3
+	h2SettingInitialWindowSize    = 0x4
+	h2SettingMaxFrameSize         = 0x5
+
+	h2FrameHeaderLen = 9
+	h2FlagACK        = 0x1
+)
+
+// h2Stats aggregates HTTP/2 connection-level statistics.
+type h2Stats struct {
+	mu    sync.Mutex
+	conns []*h2ConnStats
+
+	goAway        int64
+	rstStreamRecv int64
+	rstStreamSent int64
+	windowUpdates int64
+
+	maxConcurrentStreams uint32
+	initialWindowSize    uint32
+	maxFrameSize         uint32
+	lastGoAwayCode       uint32
+}
+
+type h2ConnStats struct {
+	streams int64
+}
+
+// newConn registers a new connection.
+func (s *h2Stats) newConn() *h2ConnStats {
+	cs := &h2ConnStats{}
+
+	s.mu.Lock()
+	s.conns = append(s.conns, cs)
+	s.mu.Unlock()
+
+	return cs
+}
+
+// String renders HTTP/2 report.
+func (s *h2Stats) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.conns) == 0 {
+		return ""
+	}
+
+	streamsHist := dynhist.Collector{BucketsLimit: 10}
+	for _, cs := range s.conns {
+		streamsHist.Add(float64(atomic.LoadInt64(&cs.streams)))
+	}
+
+	res := fmt.Sprintln("HTTP/2 connections:", len(s.conns))
+	res += fmt.Sprintln("HTTP/2 GOAWAY received:", atomic.LoadInt64(&s.goAway))
+
+	if atomic.LoadInt64(&s.goAway) > 0 {
+		res += fmt.Sprintln("HTTP/2 last GOAWAY error code:", http2.ErrCode(s.lastGoAwayCode))
+	}
+
+	res += fmt.Sprintln("HTTP/2 RST_STREAM received:", atomic.LoadInt64(&s.rstStreamRecv),
+		"sent:", atomic.LoadInt64(&s.rstStreamSent))
+	res += fmt.Sprintln("HTTP/2 WINDOW_UPDATE received:", atomic.LoadInt64(&s.windowUpdates))
+	res += fmt.Sprintln("HTTP/2 server settings: max concurrent streams", s.maxConcurrentStreams,
+		"initial window size", s.initialWindowSize, "max frame size", s.maxFrameSize)
+	res += "\nHTTP/2 streams per connection distribution:\n"
+	res += streamsHist.String() + "\n"
+
+	return res
+}
+
+// h2FrameReader sniffs frames of HTTP/2 byte stream.
+type h2FrameReader struct {
+	skip    int // Bytes of connection preface or payload to skip.
+	header  []byte
+	payload []byte
+	need    int // Payload bytes to collect.
+	onFrame func(typ, flags byte, payload []byte)
+}
+
+// feed processes bytes of the stream.
+func (r *h2FrameReader) feed(b []byte) {
+	for len(b) > 0 {
+		switch {
+		case r.skip > 0:
+			n := min(r.skip, len(b))
+			r.skip -= n
+			b = b[n:]
+		case r.need > 0:
+			n := min(r.need, len(b))
+			r.payload = append(r.payload, b[:n]...)
+			r.need -= n
+			b = b[n:]
+
+			if r.need == 0 {
+				r.onFrame(r.header[3], r.header[4], r.payload)
+				r.header = r.header[:0]
+			}
+		default:
+			n := min(h2FrameHeaderLen-len(r.header), len(b))
+			r.header = append(r.header, b[:n]...)
+			b = b[n:]
+
+			if len(r.header) < h2FrameHeaderLen {
+				continue
+			}
+
+			length := int(r.header[0])<<16 | int(r.header[1])<<8 | int(r.header[2])
+
+			switch r.header[3] {
+			case h2FrameSettings, h2FrameGoAway:
+				// Payload is needed for these frames.
+				r.payload = r.payload[:0]
+				r.need = length
+
+				if length == 0 {
+					r.onFrame(r.header[3], r.header[4], nil)
+					r.header = r.header[:0]
+				}
+			default:
+				r.onFrame(r.header[3], r.header[4], nil)
+				r.header = r.header[:0]
+				r.skip = length
+			}
+		}
+	}
+}
+
+// h2Conn collects frame statistics of HTTP/2 connection.
+type h2Conn struct {
+	net.Conn
+
+	in  *h2FrameReader
+	out *h2FrameReader
+}
+
+func newH2Conn(c net.Conn, s *h2Stats) *h2Conn {
+	cs := s.newConn()
+
+	in := &h2FrameReader{onFrame: func(typ, flags byte, payload []byte) {
+		switch typ {
+		case h2FrameGoAway:
+			atomic.AddInt64(&s.goAway, 1)
+
+			if len(payload) >= 8 {
+				s.mu.Lock()
+				s.lastGoAwayCode = binary.BigEndian.Uint32(payload[4:8])
+				s.mu.Unlock()
+			}
+		case h2FrameRSTStream:
+			atomic.AddInt64(&s.rstStreamRecv, 1)
+		case h2FrameWindowUpdate:
+			atomic.AddInt64(&s.windowUpdates, 1)
+		case h2FrameSettings:
+			if flags&h2FlagACK != 0 {
+				return
+			}
+
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			for i := 0; i+6 <= len(payload); i += 6 {
+				v := binary.BigEndian.Uint32(payload[i+2 : i+6])
+
+				switch binary.BigEndian.Uint16(payload[i : i+2]) {
+				case h2SettingMaxConcurrentStreams:
+					s.maxConcurrentStreams = v
+				case h2SettingInitialWindowSize:
+					s.initialWindowSize = v
+				case h2SettingMaxFrameSize:
+					s.maxFrameSize = v
+				}
+			}
+		}
+	}}
+
+	out := &h2FrameReader{
+		skip: len(http2.ClientPreface),
+		onFrame: func(typ, _ byte, _ []byte) {
+			switch typ {
+			case h2FrameHeaders:
+				atomic.AddInt64(&cs.streams, 1)
+			case h2FrameRSTStream:
+				atomic.AddInt64(&s.rstStreamSent, 1)
+			}
+		},
+	}
+
+	return &h2Conn{Conn: c, in: in, out: out}
+}
+
+// Read reads data from the connection.
+// Read can be made to time out and return an Error with Timeout() == true
+// after a fixed time limit; see SetDeadline and SetReadDeadline.
+func (c *h2Conn) Read(b []byte) (n int, err error) {
+	n, err = c.Conn.Read(b)
+	c.in.feed(b[:n])
+
+	return n, err
+}
+
+// Write writes data to the connection.
+// Write can be made to time out and return an Error with Timeout() == true
+// after a fixed time limit; see SetDeadline and SetWriteDeadline.
+func (c *h2Conn) Write(b []byte) (n int, err error) {
+	n, err = c.Conn.Write(b)
+	c.out.feed(b[:n])
+
+	return n, err
+}
+
+// h2Pool keeps fixed number of HTTP/2 connections per address and round-robins streams across them.
+// h2SlotWait is a delay between checks of saturated connections for available streams.
+const h2SlotWait = time.Millisecond
+
+type h2Pool struct {
+	t *http2.Transport
+	n int
+
+	mu      sync.Mutex
+	conns   map[string][]*http2.ClientConn
+	dialing map[string]chan struct{} // Closed when pending dials of address are done.
+	next    int
+}
+
+// GetClientConn returns a specific HTTP/2 connection.
+func (p *h2Pool) GetClientConn(req *http.Request, addr string) (*http2.ClientConn, error) {
+	for {
+		p.mu.Lock()
+
+		conns := p.conns[addr]
+
+		// Replace connections that can not be used anymore.
+		for i := 0; i < len(conns); i++ {
+			if st := conns[i].State(); st.Closed || st.Closing {
+				conns = append(conns[:i], conns[i+1:]...)
+				i--
+			}
+		}
+
+		p.conns[addr] = conns
+		done := p.dialing[addr]
+
+		if len(conns) >= p.n || (len(conns) > 0 && done != nil) {
+			if cc := p.pick(conns); cc != nil {
+				p.mu.Unlock()
+
+				return cc, nil
+			}
+
+			// All streams are busy, wait for a stream to finish, connection has no notification for that.
+			if done == nil {
+				p.mu.Unlock()
+
+				select {
+				case <-time.After(h2SlotWait):
+					continue
+				case <-req.Context().Done():
+					return nil, req.Context().Err()
+				}
+			}
+		}
+
+		if done != nil {
+			p.mu.Unlock()
+
+			select {
+			case <-done:
+				continue
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			}
+		}
+
+		// Reserve missing slots and dial them without holding the lock.
+		missing := p.n - len(conns)
+		done = make(chan struct{})
+
+		if p.dialing == nil {
+			p.dialing = make(map[string]chan struct{})
+		}
+
+		p.dialing[addr] = done
+		p.mu.Unlock()
+
+		dialed := make([]*http2.ClientConn, 0, missing)
+
+		var err error
+
+		for range missing {
+			var cc *http2.ClientConn
+
+			if cc, err = p.dial(req.Context(), addr); err != nil {
+				break
+			}
+
+			dialed = append(dialed, cc)
+		}
+
+		p.mu.Lock()
+		p.conns[addr] = append(p.conns[addr], dialed...)
+		delete(p.dialing, addr)
+		close(done)
+		p.mu.Unlock()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// pick returns connection with reserved stream slot or nil if all connections are saturated,
+// it must be called with mu locked.
+func (p *h2Pool) pick(conns []*http2.ClientConn) *http2.ClientConn {
+	for range conns {
+		cc := conns[p.next%len(conns)]
+		p.next++
+
+		if cc.ReserveNewRequest() {
+			return cc
+		}
+	}
+
+	return nil
+}
+
+// MarkDead removes connection from the pool.
+func (p *h2Pool) MarkDead(cc *http2.ClientConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for addr, conns := range p.conns {
+		for i, c := range conns {
+			if c == cc {
+				p.conns[addr] = append(conns[:i], conns[i+1:]...)
+
+				return
+			}
+		}
+	}
+}
+
+func (p *h2Pool) dial(ctx context.Context, addr string) (*http2.ClientConn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{} //nolint:gosec // Default min version.
+	if p.t.TLSClientConfig != nil {
+		cfg = p.t.TLSClientConfig.Clone()
+	}
+
+	if cfg.ServerName == "" {
+		cfg.ServerName = host
+	}
+
+	cfg.NextProtos = []string{http2.NextProtoTLS}
+
+	c, err := p.t.DialTLSContext(ctx, "tcp", addr, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.t.NewClientConn(c)
+}
@@ -1,22 +1,251 @@
-//go:build go1.21
-
 package nethttp
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"github.com/quic-go/quic-go"
 	"github.com/quic-go/quic-go/http3"
 )
 
 // HTTP3Available guards HTTP3 library.
 const HTTP3Available = true
 
+// h3State keeps QUIC resources shared by HTTP/3 transports of a producer.
+type h3State struct {
+	once sync.Once
+	tr   *quic.Transport
+	err  error
+
+	conns    int64
+	used0RTT int64
+}
+
+// String renders QUIC report.
+func (s *h3State) String() string {
+	conns := atomic.LoadInt64(&s.conns)
+	if conns == 0 {
+		return ""
+	}
+
+	return fmt.Sprintln("QUIC connections:", conns, "0-RTT accepted:", atomic.LoadInt64(&s.used0RTT)) + "\n"
+}
+
+// close closes QUIC connections and UDP socket.
+func (s *h3State) close() error {
+	if s.tr == nil {
+		return nil
+	}
+
+	err := s.tr.Close()
+
+	// Transport does not own the socket that was provided to it.
+	return errors.Join(err, s.tr.Conn.Close())
+}
+
+// countingPacketConn counts bytes of UDP datagrams.
+//
+// ReadMsgUDP and WriteMsgUDP are not exposed to make quic-go use ReadFrom and WriteTo.
+type countingPacketConn struct {
+	net.PacketConn
+
+	j  *JobProducer
+	uc *net.UDPConn
+}
+
+// ReadFrom reads a packet from the connection.
+func (c countingPacketConn) ReadFrom(b []byte) (n int, addr net.Addr, err error) {
+	n, addr, err = c.PacketConn.ReadFrom(b)
+	atomic.AddInt64(&c.j.bytesRead, int64(n))
+
+	return n, addr, err
+}
+
+// WriteTo writes a packet to addr.
+func (c countingPacketConn) WriteTo(b []byte, addr net.Addr) (n int, err error) {
+	n, err = c.PacketConn.WriteTo(b, addr)
+	atomic.AddInt64(&c.j.bytesWritten, int64(n))
+
+	return n, err
+}
+
+// SetReadBuffer sets the size of the operating system's receive buffer.
+func (c countingPacketConn) SetReadBuffer(bytes int) error {
+	return c.uc.SetReadBuffer(bytes)
+}
+
+// SetWriteBuffer sets the size of the operating system's transmit buffer.
+func (c countingPacketConn) SetWriteBuffer(bytes int) error {
+	return c.uc.SetWriteBuffer(bytes)
+}
+
+// SyscallConn returns a raw network connection.
+func (c countingPacketConn) SyscallConn() (syscall.RawConn, error) {
+	return c.uc.SyscallConn()
+}
+
+// init creates QUIC transport on a single UDP socket, shared by all connections.
+func (s *h3State) init(j *JobProducer) {
+	s.once.Do(func() {
+		uc, err := net.ListenUDP("udp", nil)
+		if err != nil {
+			s.err = fmt.Errorf("failed to listen UDP: %w", err)
+
+			return
+		}
+
+		s.tr = &quic.Transport{Conn: countingPacketConn{PacketConn: uc, j: j, uc: uc}}
+	})
+}
+
+// earlyDataTransport sends idempotent requests in 0-RTT when session can be resumed.
+type earlyDataTransport struct {
+	next http.RoundTripper
+}
+
+// RoundTrip executes a single HTTP transaction.
+func (t earlyDataTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var method string
+
+	switch req.Method {
+	case http.MethodGet:
+		method = http3.MethodGet0RTT
+	case http.MethodHead:
+		method = http3.MethodHead0RTT
+	default:
+		return t.next.RoundTrip(req)
+	}
+
+	r := req.Clone(req.Context())
+	r.Method = method
+
+	return t.next.RoundTrip(r)
+}
+
 func (j *JobProducer) makeTransport3() http.RoundTripper {
-	return &http3.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true, //nolint:gosec // Allow insecure mode in a dev tool.
-		},
+	t := &http3.Transport{
+		TLSClientConfig:    j.tlsConfig(),
 		DisableCompression: true,
+		QUICConfig: &quic.Config{
+			HandshakeIdleTimeout:           j.f.ConnectTimeout,
+			MaxIdleTimeout:                 j.f.QUICIdleTimeout,
+			InitialStreamReceiveWindow:     j.f.QUICStreamWindow,
+			InitialConnectionReceiveWindow: j.f.QUICConnWindow,
+		},
+	}
+
+	j.h3.init(j)
+
+	t.Dial = func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
+		if j.h3.err != nil {
+			return nil, j.h3.err
+		}
+
+		udpAddr, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			return nil, err
+		}
+
+		start := time.Now()
+
+		conn, err := j.h3.tr.DialEarly(ctx, udpAddr, tlsCfg, cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		atomic.AddInt64(&j.h3.conns, 1)
+
+		// Connection is returned before handshake completion only if 0-RTT is possible.
+		go func() {
+			select {
+			case <-conn.HandshakeComplete():
+				j.quicHist.Add(1000 * time.Since(start).Seconds())
+				j.countTLSHandshake(conn.ConnectionState().TLS)
+
+				if conn.ConnectionState().Used0RTT {
+					atomic.AddInt64(&j.h3.used0RTT, 1)
+				}
+			case <-conn.Context().Done():
+			}
+		}()
+
+		return conn, nil
+	}
+
+	var tr http.RoundTripper = t
+
+	if j.f.NoKeepalive {
+		tr = singleUseTransport3{t: t}
+	}
+
+	if j.f.QUIC0RTT {
+		tr = earlyDataTransport{next: tr}
+	}
+
+	return tr
+}
+
+// singleUseTransport3 sends every request over a new QUIC connection that is closed with response body.
+type singleUseTransport3 struct {
+	t *http3.Transport
+}
+
+// RoundTrip executes a single HTTP transaction.
+func (t singleUseTransport3) RoundTrip(req *http.Request) (*http.Response, error) {
+	addr := req.URL.Host
+	if req.URL.Port() == "" {
+		addr = net.JoinHostPort(req.URL.Hostname(), "443")
+	}
+
+	cfg := &tls.Config{} //nolint:gosec // Default min version.
+	if t.t.TLSClientConfig != nil {
+		cfg = t.t.TLSClientConfig.Clone()
+	}
+
+	if cfg.ServerName == "" {
+		cfg.ServerName = req.URL.Hostname()
+	}
+
+	cfg.NextProtos = []string{http3.NextProtoH3}
+
+	conn, err := t.t.Dial(req.Context(), addr, cfg, t.t.QUICConfig)
+	if err != nil {
+		return nil, err
 	}
+
+	resp, err := t.t.NewClientConn(conn).RoundTrip(req)
+	if err != nil {
+		_ = conn.CloseWithError(0, "")
+
+		return nil, err
+	}
+
+	resp.Body = closingBody{ReadCloser: resp.Body, close: func() {
+		_ = conn.CloseWithError(0, "")
+	}}
+
+	return resp, nil
+}
+
+// closingBody releases connection after response body is closed.
+type closingBody struct {
+	io.ReadCloser
+	close func()
+}
+
+// Close closes response body and connection.
+func (b closingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.close()
+
+	return err
 }
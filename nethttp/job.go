@@ -12,6 +12,8 @@ import (
 	"net/http"
 	"net/http/httptrace"
 	"net/url"
+	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -41,13 +43,46 @@ type JobProducer struct {
 	tlsHist  *dynhist.Collector
 	ttfbHist *dynhist.Collector
 
+	proxyHist *dynhist.Collector
+	quicHist  *dynhist.Collector
+
+	connOpened     int64
+	connReused     int64
+	connReusedIdle int64
+	connWaitHist   *dynhist.Collector
+	connIdleHist   *dynhist.Collector
+
+	tlsResumedHist *dynhist.Collector
+	tlsParams      map[string][2]int // Full and resumed handshakes by version, cipher suite and ALPN.
+	tlsSessions    tls.ClientSessionCache
+
 	upstreamHist        *dynhist.Collector
 	upstreamHistPrecise *dynhist.Collector
 
+	retries                 int64
+	retriedRequests         int64
+	retriesExhausted        int64
+	firstAttemptHist        *dynhist.Collector
+	firstAttemptHistPrecise *dynhist.Collector
+
 	f  Flags
 	lf loadgen.Flags
 
-	tr http.RoundTripper
+	tr     http.RoundTripper
+	dialer *Dialer
+	auth   *authenticator
+
+	compression CompressionStats
+	headers     *HeaderStats
+	sampler     *Sampler
+	slowest     *Slowest
+	traces      *TraceContext
+	otlp        *OTLPExporter
+	stream      *streamStats
+	sampleSize  int
+	timing      serverTiming
+	h2          h2Stats
+	h3          h3State
 
 	mu         sync.Mutex
 	respBody   map[int][]byte
@@ -95,6 +130,20 @@ func (c countingConn) Write(b []byte) (n int, err error) {
 	return n, err
 }
 
+// wrapConn counts traffic of dialed connection and binds it to authentication state.
+func (j *JobProducer) wrapConn(c net.Conn) net.Conn {
+	c = countingConn{
+		j:    j,
+		Conn: c,
+	}
+
+	if j.auth != nil {
+		c = j.auth.wrapConn(c)
+	}
+
+	return c
+}
+
 func (j *JobProducer) makeTransport() http.RoundTripper {
 	var tr http.RoundTripper
 
@@ -107,6 +156,10 @@ func (j *JobProducer) makeTransport() http.RoundTripper {
 		tr = j.makeTransport1()
 	}
 
+	if j.auth != nil {
+		tr = &authTransport{next: tr, a: j.auth}
+	}
+
 	if j.PrepareRoundTripper != nil {
 		tr = j.PrepareRoundTripper(tr)
 	}
@@ -122,23 +175,31 @@ func (j *JobProducer) makeTransport1() *http.Transport {
 		TLSHandshakeTimeout:   10 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
 		DisableCompression:    true,
+		DisableKeepAlives:     j.f.NoKeepalive,
+	}
+
+	t.TLSClientConfig = j.tlsConfig()
+
+	// Explicit proxy is handled by dialer.
+	if j.dialer.Proxy() != nil {
+		t.Proxy = nil
 	}
 
-	d := &net.Dialer{
-		Timeout:   30 * time.Second,
-		KeepAlive: 30 * time.Second,
+	if j.f.ConnectTimeout > 0 {
+		t.TLSHandshakeTimeout = j.f.ConnectTimeout
+	}
+
+	if j.f.Expect100Timeout > 0 {
+		t.ExpectContinueTimeout = j.f.Expect100Timeout
 	}
 
 	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
-		c, err := d.DialContext(ctx, network, addr)
+		c, err := j.dialer.DialContext(ctx, network, addr)
 		if err != nil {
 			return c, err
 		}
 
-		return countingConn{
-			j:    j,
-			Conn: c,
-		}, nil
+		return j.wrapConn(c), nil
 	}
 
 	concurrencyLimit := j.lf.Concurrency // Number of simultaneous jobs.
@@ -157,21 +218,90 @@ func (j *JobProducer) makeTransport2() *http2.Transport {
 		AllowHTTP:          true,
 	}
 
-	t.DialTLSContext = func(_ context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
-		c, err := tls.DialWithDialer(new(net.Dialer), network, addr, cfg)
+	t.TLSClientConfig = j.tlsConfig()
+
+	t.DialTLSContext = func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+		c, err := j.dialer.DialContext(ctx, network, addr)
 		if err != nil {
 			return c, err
 		}
 
-		return countingConn{
-			j:    j,
-			Conn: c,
-		}, nil
+		if cfg.ServerName == "" {
+			cfg = cfg.Clone()
+			cfg.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+
+		if j.f.ConnectTimeout > 0 {
+			var cancel func()
+
+			ctx, cancel = context.WithTimeout(ctx, j.f.ConnectTimeout)
+			defer cancel()
+		}
+
+		// Custom TLS dial is not traced by transport.
+		trace := httptrace.ContextClientTrace(ctx)
+		if trace != nil && trace.TLSHandshakeStart != nil {
+			trace.TLSHandshakeStart()
+		}
+
+		tc := tls.Client(c, cfg)
+		err = tc.HandshakeContext(ctx)
+
+		if trace != nil && trace.TLSHandshakeDone != nil {
+			trace.TLSHandshakeDone(tc.ConnectionState(), err)
+		}
+
+		if err != nil {
+			_ = c.Close()
+
+			return nil, err
+		}
+
+		return j.wrapConn(newH2Conn(tc, &j.h2)), nil
+	}
+
+	if j.f.H2Conns > 0 {
+		t.ConnPool = &h2Pool{t: t, n: j.f.H2Conns, conns: make(map[string][]*http2.ClientConn)}
 	}
 
 	return t
 }
 
+// tlsConfig returns client TLS config or nil for defaults.
+func (j *JobProducer) tlsConfig() *tls.Config {
+	if !j.f.Insecure && j.tlsSessions == nil {
+		return nil
+	}
+
+	return &tls.Config{
+		InsecureSkipVerify: j.f.Insecure, //nolint:gosec // Allow insecure mode in a dev tool.
+		ClientSessionCache: j.tlsSessions,
+	}
+}
+
+// countTLSHandshake registers negotiated TLS parameters.
+func (j *JobProducer) countTLSHandshake(cs tls.ConnectionState) {
+	alpn := cs.NegotiatedProtocol
+	if alpn == "" {
+		alpn = "no ALPN"
+	}
+
+	k := tls.VersionName(cs.Version) + ", " + tls.CipherSuiteName(cs.CipherSuite) + ", " + alpn
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	c := j.tlsParams[k]
+
+	if cs.DidResume {
+		c[1]++
+	} else {
+		c[0]++
+	}
+
+	j.tlsParams[k] = c
+}
+
 // NewJobProducer creates HTTP load generator.
 func NewJobProducer(f Flags, lf loadgen.Flags, options ...func(lf *loadgen.Flags, f *Flags, j loadgen.JobProducer)) (*JobProducer, error) {
 	u, err := url.Parse(f.URL)
@@ -179,27 +309,106 @@ func NewJobProducer(f Flags, lf loadgen.Flags, options ...func(lf *loadgen.Flags
 		return nil, fmt.Errorf("failed to parse URL: %w", err)
 	}
 
-	addrs, err := net.LookupHost(u.Hostname())
-	if err != nil {
-		return nil, fmt.Errorf("failed to resolve URL host: %w", err)
-	}
-
 	j := JobProducer{}
 	j.f = f
 	j.lf = lf
 
-	j.log += fmt.Sprintln("Host resolved:", strings.Join(addrs, ","))
+	if j.dialer, err = NewDialer(f); err != nil {
+		return nil, err
+	}
+
+	if p := j.dialer.Proxy(); p != nil {
+		if f.HTTP3 {
+			return nil, errors.New("proxy is not supported for HTTP/3")
+		}
+
+		// Target host may only be resolvable by proxy.
+		j.log += fmt.Sprintln("Proxy:", p.Redacted())
+	} else if us := j.dialer.UnixSocket(); us != "" {
+		if f.HTTP3 {
+			return nil, errors.New("unix socket is not supported for HTTP/3")
+		}
+
+		j.log += fmt.Sprintln("Unix socket:", us)
+	} else {
+		addrs, err := net.LookupHost(u.Hostname())
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve URL host: %w", err)
+		}
+
+		j.log += fmt.Sprintln("Host resolved:", strings.Join(addrs, ","))
+	}
+
+	if sa := j.dialer.SourceAddr(); sa != "" {
+		if f.HTTP3 {
+			return nil, errors.New("source address binding is not supported for HTTP/3")
+		}
+
+		j.log += fmt.Sprintln("Source addresses:", sa)
+	}
 
 	j.dnsHist = &dynhist.Collector{BucketsLimit: 10, WeightFunc: dynhist.LatencyWidth}
 	j.connHist = &dynhist.Collector{BucketsLimit: 10, WeightFunc: dynhist.LatencyWidth}
 	j.tlsHist = &dynhist.Collector{BucketsLimit: 10, WeightFunc: dynhist.LatencyWidth}
 	j.ttfbHist = &dynhist.Collector{BucketsLimit: 10, WeightFunc: dynhist.LatencyWidth}
+	j.proxyHist = &dynhist.Collector{BucketsLimit: 10, WeightFunc: dynhist.LatencyWidth}
+	j.quicHist = &dynhist.Collector{BucketsLimit: 10, WeightFunc: dynhist.LatencyWidth}
+	j.tlsResumedHist = &dynhist.Collector{BucketsLimit: 10, WeightFunc: dynhist.LatencyWidth}
+	j.connWaitHist = &dynhist.Collector{BucketsLimit: 10, WeightFunc: dynhist.LatencyWidth}
+	j.connIdleHist = &dynhist.Collector{BucketsLimit: 10, WeightFunc: dynhist.LatencyWidth}
+	j.tlsParams = make(map[string][2]int)
 	j.upstreamHist = &dynhist.Collector{BucketsLimit: 10, WeightFunc: dynhist.LatencyWidth}
 	j.upstreamHistPrecise = &dynhist.Collector{BucketsLimit: 100, WeightFunc: dynhist.LatencyWidth}
+	j.firstAttemptHist = &dynhist.Collector{BucketsLimit: 10, WeightFunc: dynhist.LatencyWidth}
+	j.firstAttemptHistPrecise = &dynhist.Collector{BucketsLimit: 100, WeightFunc: dynhist.LatencyWidth}
 	j.respBody = make(map[int][]byte, 5)
 	j.respHeader = make(map[int]http.Header, 5)
 	j.respProto = make(map[int]string, 5)
 
+	// Sessions are shared by transports to allow resumption with new connections.
+	if f.TLSResume || f.QUIC0RTT {
+		j.tlsSessions = tls.NewLRUClientSessionCache(0)
+	}
+
+	if j.headers, err = NewHeaderStats(f); err != nil {
+		return nil, err
+	}
+
+	j.sampleSize = SampleSize
+	if f.SampleSize > 0 {
+		j.sampleSize = f.SampleSize
+	}
+
+	if j.sampler, err = NewSampler(f); err != nil {
+		return nil, err
+	}
+
+	if j.sampler != nil {
+		j.log += fmt.Sprintln("Samples directory:", j.sampler.Dir())
+	}
+
+	j.slowest = NewSlowest(f)
+
+	if j.traces, err = NewTraceContext(f, lf.SlowResponse); err != nil {
+		return nil, err
+	}
+
+	if j.otlp, err = NewOTLPExporter(f, j.traces); err != nil {
+		return nil, err
+	}
+
+	if j.stream, err = newStreamStats(f.Stream); err != nil {
+		return nil, err
+	}
+
+	if j.auth, err = newAuthenticator(f); err != nil {
+		return nil, err
+	}
+
+	j.dialer.OnProxyConnect = func(elapsed time.Duration) {
+		j.proxyHist.Add(1000 * elapsed.Seconds())
+	}
+
 	for _, o := range options {
 		o(&lf, &f, &j)
 	}
@@ -213,12 +422,39 @@ func NewJobProducer(f Flags, lf loadgen.Flags, options ...func(lf *loadgen.Flags
 	return &j, nil
 }
 
+// Close exports collected data and releases resources of the producer, it should be called after loadgen.Run.
+func (j *JobProducer) Close() error {
+	out := j.lf.Output
+	if out == nil {
+		out = os.Stdout
+	}
+
+	if j.otlp != nil {
+		j.otlp.Flush()
+
+		_, _ = fmt.Fprint(out, j.otlp.String())
+	}
+
+	var err error
+
+	if j.slowest != nil {
+		err = j.slowest.Export(out)
+	}
+
+	return errors.Join(err, j.h3.close())
+}
+
 // String prints results.
 func (j *JobProducer) String() string {
 	j.mu.Lock()
 	defer j.mu.Unlock()
 
 	if len(j.respBody) == 0 {
+		// Trace IDs of failed requests are still reported when there were no responses.
+		if j.traces != nil {
+			return j.log + j.traces.String()
+		}
+
 		return ""
 	}
 
@@ -235,11 +471,52 @@ func (j *JobProducer) String() string {
 	if bytesRead > 0 && bytesWritten > 0 && atomic.LoadInt64(&j.total) > 0 {
 		res += fmt.Sprintln("Bytes read", report.ByteSize(bytesRead), "total,",
 			report.ByteSize(bytesRead/atomic.LoadInt64(&j.total)), "avg,", report.ByteSize(int64(dlSpeed))+"/s")
-		res += fmt.Sprintln("Bytes written", report.ByteSize(bytesWritten), "total,",
-			report.ByteSize(bytesWritten/atomic.LoadInt64(&j.total)), "avg,", report.ByteSize(int64(ulSpeed))+"/s")
+		res += fmt.Sprint("Bytes written ", report.ByteSize(bytesWritten), " total, ",
+			report.ByteSize(bytesWritten/atomic.LoadInt64(&j.total)), " avg")
+
+		// Write time is not traced by HTTP/3 transport.
+		if writeTime > 0 {
+			res += ", " + report.ByteSize(int64(ulSpeed)) + "/s"
+		}
+
+		res += "\n"
 		res += "\n"
 	}
 
+	if lr := j.dialer.LimitRate(); lr > 0 {
+		res += fmt.Sprintln("Per-connection throughput", report.ByteSize(int64(j.dialer.Throughput().BytesPerSecond()))+"/s",
+			"avg, limited to", report.ByteSize(lr)+"/s")
+		res += "\n"
+	}
+
+	if cs := j.compression.String(); cs != "" {
+		res += cs + "\n"
+	}
+
+	if opened, reused := atomic.LoadInt64(&j.connOpened), atomic.LoadInt64(&j.connReused); opened+reused > 0 {
+		res += fmt.Sprintf("Connections: %d opened, %d reused (%.2f%% reuse ratio), %d reused idle\n\n",
+			opened, reused, 100*float64(reused)/float64(opened+reused), atomic.LoadInt64(&j.connReusedIdle))
+	}
+
+	res += j.h2.String()
+	res += j.h3.String()
+
+	if j.f.Retry > 0 {
+		res += fmt.Sprintln("Retries:", atomic.LoadInt64(&j.retries), "total,",
+			atomic.LoadInt64(&j.retriedRequests), "requests retried,",
+			atomic.LoadInt64(&j.retriesExhausted), "requests exhausted retries")
+		res += "\n"
+
+		res += "First attempt latency (to response headers) percentiles:\n"
+		res += fmt.Sprintf("99%%: %.2fms\n", j.firstAttemptHistPrecise.Percentile(99))
+		res += fmt.Sprintf("95%%: %.2fms\n", j.firstAttemptHistPrecise.Percentile(95))
+		res += fmt.Sprintf("90%%: %.2fms\n", j.firstAttemptHistPrecise.Percentile(90))
+		res += fmt.Sprintf("50%%: %.2fms\n\n", j.firstAttemptHistPrecise.Percentile(50))
+
+		res += "First attempt latency distribution in ms:\n"
+		res += j.firstAttemptHist.String() + "\n"
+	}
+
 	if j.upstreamHist.Count > 0 {
 		res += "Envoy upstream latency percentiles:\n"
 		res += fmt.Sprintf("99%%: %.0fms\n", j.upstreamHistPrecise.Percentile(99))
@@ -251,16 +528,58 @@ func (j *JobProducer) String() string {
 		res += j.upstreamHist.String() + "\n"
 	}
 
+	res += j.headers.String()
+	res += j.timing.String()
+
+	if j.stream != nil {
+		res += j.stream.String()
+	}
+
+	if j.slowest != nil {
+		res += j.slowest.String()
+	}
+
+	if j.traces != nil {
+		res += j.traces.String()
+	}
+
 	if j.dnsHist.Count > 0 {
 		res += "DNS latency distribution in ms:\n"
 		res += j.dnsHist.String() + "\n"
 	}
 
+	if len(j.tlsParams) > 0 {
+		params := make([]string, 0, len(j.tlsParams))
+		for k := range j.tlsParams {
+			params = append(params, k)
+		}
+
+		sort.Strings(params)
+
+		res += "TLS handshakes by version, cipher suite and ALPN:\n"
+
+		for _, k := range params {
+			res += fmt.Sprintf("[%s] full %d, resumed %d\n", k, j.tlsParams[k][0], j.tlsParams[k][1])
+		}
+
+		res += "\n"
+	}
+
 	if j.tlsHist.Count > 0 {
-		res += "TLS handshake latency distribution in ms:\n"
+		res += "Full TLS handshake latency distribution in ms:\n"
 		res += j.tlsHist.String() + "\n"
 	}
 
+	if j.tlsResumedHist.Count > 0 {
+		res += "Resumed TLS handshake latency distribution in ms:\n"
+		res += j.tlsResumedHist.String() + "\n"
+	}
+
+	if j.quicHist.Count > 0 {
+		res += "QUIC handshake latency distribution in ms:\n"
+		res += j.quicHist.String() + "\n"
+	}
+
 	if j.ttfbHist.Count > 0 {
 		res += "Time to first resp byte (TTFB) distribution in ms:\n"
 		res += j.ttfbHist.String() + "\n"
@@ -271,6 +590,21 @@ func (j *JobProducer) String() string {
 		res += j.connHist.String() + "\n"
 	}
 
+	if j.connWaitHist.Count > 0 {
+		res += "Wait for connection latency distribution in ms:\n"
+		res += j.connWaitHist.String() + "\n"
+	}
+
+	if j.connIdleHist.Count > 0 {
+		res += "Idle time of reused connections distribution in ms:\n"
+		res += j.connIdleHist.String() + "\n"
+	}
+
+	if j.proxyHist.Count > 0 {
+		res += "Proxy connect latency distribution in ms:\n"
+		res += j.proxyHist.String() + "\n"
+	}
+
 	res += "Responses by status code\n"
 
 	codes := ""
@@ -301,7 +635,25 @@ const SampleSize = 1000
 
 // Job runs single item of load.
 func (j *JobProducer) Job(i int) (time.Duration, error) {
-	var start, dnsStart, connStart, tlsStart, dlStart time.Time
+	if j.traces == nil {
+		return j.job(i)
+	}
+
+	elapsed, err := j.job(i)
+	j.traces.Done(i, elapsed, err)
+
+	return elapsed, err
+}
+
+func (j *JobProducer) job(i int) (time.Duration, error) {
+	var (
+		// Timings are guarded by mu, trace hooks of HTTP/2 are called from writer and reader goroutines.
+		mu                                                          sync.Mutex
+		start, getConnStart, dnsStart, connStart, tlsStart, dlStart time.Time
+		writeStart, wroteRequest                                    time.Time
+		phases                                                      Phases
+		phaseSpans                                                  [5]PhaseSpan // DNS, connect, TLS, write, TTFB.
+	)
 
 	var body io.Reader
 	if j.f.Body != "" {
@@ -317,59 +669,203 @@ func (j *JobProducer) Job(i int) (time.Duration, error) {
 		req.Header.Set(k, v)
 	}
 
+	if j.traces != nil {
+		j.traces.Headers(i, req.Header.Set)
+	}
+
 	trace := &httptrace.ClientTrace{
+		GetConn: func(_ string) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			getConnStart = time.Now()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			// Request is written to connection after it is established.
+			writeStart = time.Now()
+
+			// Not all transports trace GetConn.
+			if !getConnStart.IsZero() {
+				phases.ConnWait = 1000 * time.Since(getConnStart).Seconds()
+				j.connWaitHist.Add(phases.ConnWait)
+			}
+
+			if !info.Reused {
+				atomic.AddInt64(&j.connOpened, 1)
+
+				return
+			}
+
+			atomic.AddInt64(&j.connReused, 1)
+
+			if info.WasIdle {
+				atomic.AddInt64(&j.connReusedIdle, 1)
+				j.connIdleHist.Add(1000 * info.IdleTime.Seconds())
+			}
+		},
+
 		DNSStart: func(_ httptrace.DNSStartInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+
 			dnsStart = time.Now()
 		},
 		DNSDone: func(dnsInfo httptrace.DNSDoneInfo) {
-			j.dnsHist.Add(1000 * time.Since(dnsStart).Seconds())
+			mu.Lock()
+			defer mu.Unlock()
+
+			phaseSpans[0] = PhaseSpan{Name: "dns", Start: dnsStart, End: time.Now()}
+			phases.DNS = 1000 * time.Since(dnsStart).Seconds()
+			j.dnsHist.Add(phases.DNS)
 		},
 
 		ConnectStart: func(_, _ string) {
+			mu.Lock()
+			defer mu.Unlock()
+
 			connStart = time.Now()
 		},
 		ConnectDone: func(network, addr string, err error) {
-			j.connHist.Add(1000 * time.Since(connStart).Seconds())
+			mu.Lock()
+			defer mu.Unlock()
+
+			phaseSpans[1] = PhaseSpan{Name: "connect", Start: connStart, End: time.Now()}
+			phases.Connect = 1000 * time.Since(connStart).Seconds()
+			j.connHist.Add(phases.Connect)
 		},
 
 		TLSHandshakeStart: func() {
+			mu.Lock()
+			defer mu.Unlock()
+
 			tlsStart = time.Now()
 		},
-		TLSHandshakeDone: func(tls.ConnectionState, error) {
-			j.tlsHist.Add(1000 * time.Since(tlsStart).Seconds())
+		TLSHandshakeDone: func(cs tls.ConnectionState, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			writeStart = time.Now()
+			phaseSpans[2] = PhaseSpan{Name: "tls", Start: tlsStart, End: writeStart}
+			ms := 1000 * writeStart.Sub(tlsStart).Seconds()
+			phases.TLS = ms
+
+			if err != nil {
+				j.tlsHist.Add(ms)
+
+				return
+			}
+
+			if cs.DidResume {
+				j.tlsResumedHist.Add(ms)
+			} else {
+				j.tlsHist.Add(ms)
+			}
+
+			j.countTLSHandshake(cs)
 		},
 
 		WroteRequest: func(_ httptrace.WroteRequestInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+
 			dlStart = time.Now()
+			wroteRequest = dlStart
+
+			// Transports without connection tracing only have request start.
+			if writeStart.IsZero() {
+				writeStart = start
+			}
+
+			phaseSpans[3] = PhaseSpan{Name: "write", Start: writeStart, End: dlStart}
+			phases.Write = 1000 * dlStart.Sub(writeStart).Seconds()
 
 			atomic.AddInt64(&j.writeTime, int64(time.Since(start)))
 		},
 
 		GotFirstResponseByte: func() {
+			mu.Lock()
+			defer mu.Unlock()
+
 			dlStart = time.Now()
+			if wroteRequest.IsZero() {
+				wroteRequest = start
+			}
+
+			phaseSpans[4] = PhaseSpan{Name: "ttfb", Start: wroteRequest, End: dlStart}
 
-			j.ttfbHist.Add(1000 * time.Since(start).Seconds())
+			phases.TTFB = 1000 * dlStart.Sub(wroteRequest).Seconds()
+
+			j.ttfbHist.Add(phases.TTFB)
 		},
 	}
 	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
 
+	// Connection is closed after response instead of rebuilding transport for every request.
+	req.Close = j.f.NoKeepalive
+
 	if j.PrepareRequest != nil {
 		if err := j.PrepareRequest(i, req); err != nil {
 			return 0, fmt.Errorf("failed to prepare request: %w", err)
 		}
 	}
 
-	tr := j.tr
-	// Keep alive flag here.
-	if j.f.NoKeepalive {
-		tr = j.makeTransport()
-	}
-
+	mu.Lock()
 	start = time.Now()
 	dlStart = start
+	reqStart := start
+	mu.Unlock()
+
+	resp, cancel, err := j.roundTrip(j.tr, req, func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		start = time.Now()
+		dlStart = start
+		writeStart = time.Time{}
+		wroteRequest = time.Time{}
+	})
+	defer cancel()
+
+	// Snapshot of timings, late hooks of HTTP/2 may still be running.
+	timings := func() (Phases, []PhaseSpan, time.Time, time.Time) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		spans := phaseSpans
+
+		return phases, spans[:], start, dlStart
+	}
 
-	resp, err := tr.RoundTrip(req)
 	if err != nil {
+		ph, spans, _, _ := timings()
+
+		if j.sampler != nil {
+			if serr := j.sampler.Save(0, time.Since(reqStart), func() []byte {
+				return append(dumpRequest(req), "\n"+err.Error()+"\n"...)
+			}); serr != nil {
+				return 0, errors.Join(err, serr)
+			}
+		}
+
+		if j.slowest != nil {
+			j.slowest.Add(time.Since(reqStart), func() SlowRequest {
+				r := SlowRequest{Index: i, Start: reqStart, Phases: ph, Error: err.Error()}
+				r.RequestID, r.TraceParent = TraceIDs(req.Header.Get)
+
+				return r
+			})
+		}
+
+		if j.otlp != nil {
+			j.otlp.Add(RequestSpan{
+				Index: i, Method: req.Method, URL: req.URL.String(),
+				Start: reqStart, End: time.Now(), Err: err, Phases: spans,
+			})
+		}
+
 		return 0, err
 	}
 
@@ -381,25 +877,62 @@ func (j *JobProducer) Job(i int) (time.Duration, error) {
 		}
 	}
 
+	if !j.headers.Empty() {
+		j.headers.Add(resp.Header.Get)
+	}
+
+	if st := resp.Header.Values("Server-Timing"); len(st) > 0 {
+		j.timing.add(st)
+	}
+
 	cnt := atomic.AddInt64(&j.respCode[resp.StatusCode], 1)
 
-	if cnt == 1 {
+	var (
+		respBody io.Reader = resp.Body
+		dr       *decodingReader
+	)
+
+	if ce := resp.Header.Get("Content-Encoding"); ce != "" && !j.f.IgnoreResponseBody && DecodingSupported(ce) {
+		dr = newDecodingReader(ce, resp.Body)
+		defer dr.close()
+
+		respBody = dr
+	}
+
+	var capture *limitedBuffer
+
+	if j.sampler != nil {
+		capture = &limitedBuffer{limit: j.sampler.Size()}
+		respBody = io.TeeReader(respBody, capture)
+	}
+
+	var streamSample *limitedBuffer
+
+	if cnt == 1 && j.stream != nil {
+		// Sample is captured while reading stream to avoid waiting for enough bytes.
+		streamSample = &limitedBuffer{limit: j.sampleSize + 1}
+		respBody = io.TeeReader(respBody, streamSample)
+	} else if cnt == 1 {
 		j.mu.Lock()
 
 		// Read a few bytes of response to save as sample.
-		body := make([]byte, SampleSize+1)
+		body := make([]byte, j.sampleSize+1)
 
-		n, err := io.ReadAtLeast(resp.Body, body, SampleSize+1)
+		n, err := io.ReadAtLeast(respBody, body, j.sampleSize+1)
 		if err != nil && err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) {
+			j.mu.Unlock()
+
+			_ = resp.Body.Close()
+
 			return 0, err
 		}
 
 		body = body[0:n]
 
-		if resp.Header.Get("Content-Encoding") != "" {
+		if resp.Header.Get("Content-Encoding") != "" && dr == nil {
 			j.respBody[resp.StatusCode] = []byte("<" + resp.Header.Get("Content-Encoding") + "-encoded-content>")
 		} else {
-			j.respBody[resp.StatusCode] = report.PeekBody(body, SampleSize)
+			j.respBody[resp.StatusCode] = report.PeekBody(body, j.sampleSize)
 		}
 
 		j.respHeader[resp.StatusCode] = resp.Header
@@ -408,13 +941,37 @@ func (j *JobProducer) Job(i int) (time.Duration, error) {
 		j.mu.Unlock()
 	}
 
-	if !j.f.IgnoreResponseBody {
-		_, err = io.Copy(io.Discard, resp.Body)
+	ph, spans, respStart, dl := timings()
+
+	if j.stream != nil {
+		err = j.stream.read(respBody, respStart)
+
+		if streamSample != nil {
+			j.mu.Lock()
+			j.respBody[resp.StatusCode] = report.PeekBody(streamSample.buf, j.sampleSize)
+			j.respHeader[resp.StatusCode] = resp.Header
+			j.respProto[resp.StatusCode] = resp.Proto
+			j.mu.Unlock()
+		}
+
+		if err != nil {
+			_ = resp.Body.Close()
+
+			return 0, err
+		}
+	} else if !j.f.IgnoreResponseBody {
+		_, err = io.Copy(io.Discard, respBody)
 		if err != nil {
+			_ = resp.Body.Close()
+
 			return 0, err
 		}
 	}
 
+	if dr != nil {
+		j.compression.Add(dr.encoding, dr.wire.n, dr.decoded.n, dr.decodeTime())
+	}
+
 	err = resp.Body.Close()
 	if err != nil {
 		return 0, err
@@ -422,24 +979,120 @@ func (j *JobProducer) Job(i int) (time.Duration, error) {
 
 	done := time.Now()
 
-	atomic.AddInt64(&j.readTime, int64(done.Sub(dlStart)))
-	si := done.Sub(start)
+	atomic.AddInt64(&j.readTime, int64(done.Sub(dl)))
+	si := done.Sub(reqStart)
 
 	atomic.AddInt64(&j.total, 1)
 
+	if j.sampler != nil {
+		if err := j.sampler.Save(resp.StatusCode, si, func() []byte {
+			return append(dumpRequest(req), dumpResponse(resp, capture.buf)...)
+		}); err != nil {
+			return 0, err
+		}
+	}
+
+	if j.slowest != nil {
+		j.slowest.Add(si, func() SlowRequest {
+			r := SlowRequest{Index: i, Start: reqStart, Phases: ph, Status: resp.StatusCode, Header: resp.Header.Clone()}
+			r.RequestID, r.TraceParent = TraceIDs(req.Header.Get, resp.Header.Get)
+
+			return r
+		})
+	}
+
+	if j.otlp != nil {
+		j.otlp.Add(RequestSpan{
+			Index: i, Method: req.Method, URL: req.URL.String(),
+			Start: reqStart, End: done, Status: resp.StatusCode, Phases: spans,
+		})
+	}
+
 	return si, nil
 }
 
+// dumpRequest renders request line, headers and body.
+func dumpRequest(req *http.Request) []byte {
+	b := bytes.NewBuffer(nil)
+
+	_, _ = fmt.Fprintf(b, "%s %s %s\r\nHost: %s\r\n", req.Method, req.URL.RequestURI(), req.Proto, req.URL.Host)
+	_ = req.Header.Write(b)
+	b.WriteString("\r\n")
+
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			_, _ = io.Copy(b, body)
+			b.WriteString("\n")
+		}
+	}
+
+	return b.Bytes()
+}
+
+// dumpResponse renders status line, headers and sampled body.
+func dumpResponse(resp *http.Response, body []byte) []byte {
+	b := bytes.NewBuffer(nil)
+
+	_, _ = fmt.Fprintf(b, "\n%s %s\r\n", resp.Proto, resp.Status)
+	_ = resp.Header.Write(b)
+	b.WriteString("\r\n")
+	b.Write(body)
+
+	return b.Bytes()
+}
+
 // Flags control HTTP load setup.
 type Flags struct {
-	HeaderMap          map[string]string
-	URL                string
-	Body               string
-	Method             string
-	NoKeepalive        bool
-	Compressed         bool
-	Fast               bool
-	IgnoreResponseBody bool
-	HTTP2              bool
-	HTTP3              bool
+	HeaderMap            map[string]string
+	URL                  string
+	Body                 string
+	Method               string
+	NoKeepalive          bool
+	Compressed           bool
+	Fast                 bool
+	IgnoreResponseBody   bool
+	HTTP2                bool
+	HTTP3                bool
+	Proxy                string
+	UnixSocket           string
+	Interface            string
+	LocalPort            string
+	SourceIPs            []string
+	LimitRate            int64
+	ConnectTimeout       time.Duration
+	MaxTime              time.Duration
+	Expect100Timeout     time.Duration
+	Retry                int
+	RetryDelay           time.Duration
+	RetryBackoff         time.Duration
+	RetryMaxTime         time.Duration
+	RetryOnStatus        []int
+	RetryConnRefused     bool
+	User                 string
+	AuthDigest           bool
+	AuthAny              bool
+	AWSSigV4             string
+	H2Conns              int
+	Insecure             bool
+	Pipeline             int
+	PipelineConns        int
+	TLSResume            bool
+	QUIC0RTT             bool
+	QUICIdleTimeout      time.Duration
+	QUICStreamWindow     uint64
+	QUICConnWindow       uint64
+	CollectHeader        []string
+	CollectHeaderLatency []string
+	SampleCount          int
+	SampleSize           int
+	SampleDir            string
+	SlowestCount         int
+	SlowestJSON          string
+	TraceParent          bool
+	RequestID            bool
+	RunID                string
+	OTLPEndpoint         string
+	OTLPSampleRatio      float64
+	OTLPServiceName      string
+	Stream               string
 }
@@ -1,16 +1,36 @@
 package nethttp_test
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/vearutop/plt/loadgen"
 	"github.com/vearutop/plt/nethttp"
+	"golang.org/x/net/http2"
 )
 
 func TestNewJobProducer(t *testing.T) {
@@ -90,3 +110,1606 @@ func BenchmarkJobProducer_Job(b *testing.B) {
 	b.ReportAllocs()
 	require.NoError(b, loadgen.Run(lf, j))
 }
+
+func BenchmarkJobProducer_Job_noKeepalive(b *testing.B) {
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+	defer srv.Close()
+
+	out := bytes.NewBuffer(nil)
+
+	lf := loadgen.Flags{
+		Number:       b.N,
+		Concurrency:  5,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       out,
+	}
+	f := nethttp.Flags{
+		HeaderMap:   map[string]string{},
+		URL:         srv.URL,
+		Method:      http.MethodGet,
+		NoKeepalive: true,
+	}
+	j, err := nethttp.NewJobProducer(f, lf)
+	require.NoError(b, err)
+
+	b.ReportAllocs()
+	require.NoError(b, loadgen.Run(lf, j))
+}
+
+func TestNewJobProducer_proxy(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+	defer srv.Close()
+
+	var tunnels int64
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !assert.Equal(t, http.MethodConnect, r.Method) ||
+			!assert.Equal(t, "Basic dXNlcjpwYXNz", r.Header.Get("Proxy-Authorization")) {
+			return
+		}
+
+		atomic.AddInt64(&tunnels, 1)
+
+		upstream, err := net.Dial("tcp", r.Host)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		c, _, err := w.(http.Hijacker).Hijack()
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		_, err = c.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		go func() {
+			_, _ = io.Copy(upstream, c)
+			_ = upstream.Close()
+		}()
+
+		_, _ = io.Copy(c, upstream)
+		_ = c.Close()
+	}))
+	defer proxy.Close()
+
+	out := bytes.NewBuffer(nil)
+
+	lf := loadgen.Flags{
+		Number:       10,
+		Concurrency:  2,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       out,
+	}
+	f := nethttp.Flags{
+		HeaderMap: map[string]string{},
+		URL:       srv.URL,
+		Method:    http.MethodGet,
+		Proxy:     "http://user:pass@" + strings.TrimPrefix(proxy.URL, "http://"),
+	}
+	j, err := nethttp.NewJobProducer(f, lf)
+	require.NoError(t, err)
+
+	require.NoError(t, loadgen.Run(lf, j))
+	assert.Equal(t, map[string]int{"200": 10}, j.RequestCounts())
+	assert.Positive(t, atomic.LoadInt64(&tunnels))
+	assert.Contains(t, out.String(), "Proxy connect latency distribution in ms:")
+	assert.NotContains(t, out.String(), "pass")
+}
+
+func TestDialer_connectBuffered(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	defer func() {
+		_ = l.Close()
+	}()
+
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		defer func() {
+			_ = c.Close()
+		}()
+
+		if _, err := http.ReadRequest(bufio.NewReader(c)); err != nil {
+			return
+		}
+
+		// Tunneled bytes follow CONNECT response in the same packet.
+		_, _ = c.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\nhello"))
+	}()
+
+	d, err := nethttp.NewDialer(nethttp.Flags{Proxy: l.Addr().String()})
+	require.NoError(t, err)
+
+	c, err := d.DialContext(context.Background(), "tcp", "example.com:80")
+	require.NoError(t, err)
+
+	defer func() {
+		_ = c.Close()
+	}()
+
+	b, err := io.ReadAll(c)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+}
+
+// socksServer starts SOCKS5 proxy without authentication, requested targets are stored in targets.
+func socksServer(t *testing.T, targets *sync.Map) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go serveSocks(c, targets)
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func serveSocks(c net.Conn, targets *sync.Map) {
+	defer func() {
+		_ = c.Close()
+	}()
+
+	b := make([]byte, 256)
+
+	// Greeting: version, number of methods, methods.
+	if _, err := io.ReadFull(c, b[:2]); err != nil {
+		return
+	}
+
+	if _, err := io.ReadFull(c, b[:b[1]]); err != nil {
+		return
+	}
+
+	if _, err := c.Write([]byte{5, 0}); err != nil {
+		return
+	}
+
+	// Request: version, command, reserved, address type, address, port.
+	if _, err := io.ReadFull(c, b[:4]); err != nil {
+		return
+	}
+
+	var host string
+
+	switch b[3] {
+	case 1, 4:
+		ip := make(net.IP, 4)
+		if b[3] == 4 {
+			ip = make(net.IP, 16)
+		}
+
+		if _, err := io.ReadFull(c, ip); err != nil {
+			return
+		}
+
+		host = ip.String()
+	case 3:
+		if _, err := io.ReadFull(c, b[:1]); err != nil {
+			return
+		}
+
+		n := int(b[0])
+
+		if _, err := io.ReadFull(c, b[:n]); err != nil {
+			return
+		}
+
+		host = string(b[:n])
+	}
+
+	if _, err := io.ReadFull(c, b[:2]); err != nil {
+		return
+	}
+
+	target := net.JoinHostPort(host, strconv.Itoa(int(b[0])<<8|int(b[1])))
+	targets.Store(target, true)
+
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		_, _ = c.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+
+		return
+	}
+
+	defer func() {
+		_ = upstream.Close()
+	}()
+
+	if _, err := c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0}); err != nil {
+		return
+	}
+
+	go func() {
+		_, _ = io.Copy(upstream, c)
+		_ = upstream.Close()
+	}()
+
+	_, _ = io.Copy(c, upstream)
+}
+
+func TestNewJobProducer_socks5(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+	defer srv.Close()
+
+	port := strconv.Itoa(srv.Listener.Addr().(*net.TCPAddr).Port)
+
+	for scheme, target := range map[string]string{
+		"socks5":  "127.0.0.1:" + port, // Resolved locally.
+		"socks5h": "localhost:" + port, // Resolved by proxy.
+	} {
+		t.Run(scheme, func(t *testing.T) {
+			var targets sync.Map
+
+			out := bytes.NewBuffer(nil)
+
+			lf := loadgen.Flags{
+				Number:       10,
+				Concurrency:  2,
+				Duration:     time.Minute,
+				SlowResponse: time.Second,
+				Output:       out,
+			}
+			f := nethttp.Flags{
+				HeaderMap: map[string]string{},
+				URL:       "http://localhost:" + port + "/",
+				Method:    http.MethodGet,
+				Proxy:     scheme + "://" + socksServer(t, &targets),
+			}
+			j, err := nethttp.NewJobProducer(f, lf)
+			require.NoError(t, err)
+
+			require.NoError(t, loadgen.Run(lf, j))
+			assert.Equal(t, map[string]int{"200": 10}, j.RequestCounts())
+			assert.Contains(t, out.String(), "Proxy connect latency distribution in ms:")
+
+			var seen []string
+
+			targets.Range(func(k, _ any) bool {
+				seen = append(seen, k.(string))
+
+				return true
+			})
+
+			assert.Equal(t, []string{target}, seen)
+		})
+	}
+}
+
+func TestNewJobProducer_unixSocket(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "plt.sock")
+
+	l, err := net.Listen("unix", sock)
+	require.NoError(t, err)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "example.com", r.Host)
+	}))
+	srv.Listener = l
+	srv.Start()
+
+	defer srv.Close()
+
+	out := bytes.NewBuffer(nil)
+
+	lf := loadgen.Flags{
+		Number:       10,
+		Concurrency:  2,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       out,
+	}
+	f := nethttp.Flags{
+		HeaderMap:  map[string]string{},
+		URL:        "http://example.com/",
+		Method:     http.MethodGet,
+		UnixSocket: sock,
+	}
+	j, err := nethttp.NewJobProducer(f, lf)
+	require.NoError(t, err)
+
+	require.NoError(t, loadgen.Run(lf, j))
+	assert.Equal(t, map[string]int{"200": 10}, j.RequestCounts())
+	assert.Contains(t, out.String(), "Unix socket: "+sock)
+	assert.NotContains(t, out.String(), "DNS latency")
+}
+
+func TestNewJobProducer_sourceIPs(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		remotes = map[string]int{}
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		require.NoError(t, err)
+
+		mu.Lock()
+		remotes[host]++
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	out := bytes.NewBuffer(nil)
+
+	lf := loadgen.Flags{
+		Number:       10,
+		Concurrency:  1,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       out,
+	}
+	f := nethttp.Flags{
+		HeaderMap:   map[string]string{},
+		URL:         strings.Replace(srv.URL, "127.0.0.1", "localhost", 1),
+		Method:      http.MethodGet,
+		NoKeepalive: true,
+		SourceIPs:   []string{"127.0.0.1", "::1", "127.0.0.2"},
+	}
+	j, err := nethttp.NewJobProducer(f, lf)
+	require.NoError(t, err)
+
+	// IPv6 source address is skipped for IPv4 target.
+	require.NoError(t, loadgen.Run(lf, j))
+	assert.Equal(t, map[string]int{"200": 10}, j.RequestCounts())
+	assert.Equal(t, map[string]int{"127.0.0.1": 5, "127.0.0.2": 5}, remotes)
+	assert.Contains(t, out.String(), "Source addresses: 127.0.0.1,::1,127.0.0.2")
+
+	// Host name is resolved once per connection.
+	assert.Regexp(t, `DNS latency distribution in ms:\n.+\(10 events\)\n`, out.String())
+}
+
+func TestNewJobProducer_sourcePorts(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		remotes = map[string]int{}
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		remotes[r.RemoteAddr]++
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	lf := loadgen.Flags{
+		Number:       4,
+		Concurrency:  1,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       bytes.NewBuffer(nil),
+	}
+	f := nethttp.Flags{
+		HeaderMap:   map[string]string{},
+		URL:         srv.URL,
+		Method:      http.MethodGet,
+		NoKeepalive: true,
+		SourceIPs:   []string{"127.0.0.1", "127.0.0.2"},
+		LocalPort:   "45170-45171",
+	}
+	j, err := nethttp.NewJobProducer(f, lf)
+	require.NoError(t, err)
+
+	// Every pair of source IP and port is used.
+	require.NoError(t, loadgen.Run(lf, j))
+	assert.Equal(t, map[string]int{"200": 4}, j.RequestCounts())
+	assert.Equal(t, map[string]int{
+		"127.0.0.1:45170": 1, "127.0.0.2:45170": 1,
+		"127.0.0.1:45171": 1, "127.0.0.2:45171": 1,
+	}, remotes)
+}
+
+func TestNewJobProducer_limitRate(t *testing.T) {
+	body := strings.Repeat("a", 10000)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+
+		_, _ = w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	out := bytes.NewBuffer(nil)
+
+	lf := loadgen.Flags{
+		Number:       4,
+		Concurrency:  2,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       out,
+	}
+	f := nethttp.Flags{
+		HeaderMap: map[string]string{},
+		URL:       srv.URL,
+		Method:    http.MethodGet,
+		LimitRate: 50000,
+	}
+	j, err := nethttp.NewJobProducer(f, lf)
+	require.NoError(t, err)
+
+	start := time.Now()
+
+	require.NoError(t, loadgen.Run(lf, j))
+	assert.Equal(t, map[string]int{"200": 4}, j.RequestCounts())
+	// Two sequential 10KB responses per connection at 50KB/s.
+	assert.Greater(t, time.Since(start), 300*time.Millisecond)
+
+	// Waiting for response is not counted as transfer time.
+	m := regexp.MustCompile(`Per-connection throughput ([\d.]+)KB/s avg, limited to 48.8KB/s`).FindStringSubmatch(out.String())
+	require.Len(t, m, 2, out.String())
+
+	kbps, err := strconv.ParseFloat(m[1], 64)
+	require.NoError(t, err)
+	assert.Greater(t, kbps, 35.0)
+	assert.Less(t, kbps, 60.0)
+}
+
+func TestNewJobProducer_retry(t *testing.T) {
+	var hits int64
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.Equal(t, "foo", string(body))
+
+		// Every first attempt fails.
+		if atomic.AddInt64(&hits, 1)%2 == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	out := bytes.NewBuffer(nil)
+
+	lf := loadgen.Flags{
+		Number:       5,
+		Concurrency:  1,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       out,
+	}
+	f := nethttp.Flags{
+		HeaderMap:  map[string]string{},
+		URL:        srv.URL,
+		Body:       "foo",
+		Method:     http.MethodPost,
+		Retry:      2,
+		RetryDelay: time.Millisecond,
+	}
+	j, err := nethttp.NewJobProducer(f, lf)
+	require.NoError(t, err)
+
+	require.NoError(t, loadgen.Run(lf, j))
+	assert.Equal(t, map[string]int{"200": 5}, j.RequestCounts())
+	assert.Equal(t, int64(10), atomic.LoadInt64(&hits))
+	assert.Contains(t, out.String(), "Retries: 5 total, 5 requests retried, 0 requests exhausted retries")
+	assert.Contains(t, out.String(), "First attempt latency distribution in ms:")
+}
+
+func TestNewJobProducer_digest(t *testing.T) {
+	var challenges int64
+
+	md5hex := func(s string) string {
+		h := md5.Sum([]byte(s))
+
+		return hex.EncodeToString(h[:])
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if auth == "" {
+			atomic.AddInt64(&challenges, 1)
+			w.Header().Set("WWW-Authenticate", `Digest realm="test", qop="auth", nonce="abc", opaque="xyz"`)
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		params := map[string]string{}
+
+		for _, p := range strings.Split(strings.TrimPrefix(auth, "Digest "), ", ") {
+			k, v, _ := strings.Cut(p, "=")
+			params[k] = strings.Trim(v, `"`)
+		}
+
+		ha1 := md5hex("user:test:pass")
+		ha2 := md5hex(r.Method + ":" + r.URL.RequestURI())
+		expected := md5hex(ha1 + ":abc:" + params["nc"] + ":" + params["cnonce"] + ":auth:" + ha2)
+
+		if params["response"] != expected || params["opaque"] != "xyz" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer srv.Close()
+
+	lf := loadgen.Flags{
+		Number:       10,
+		Concurrency:  1,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       bytes.NewBuffer(nil),
+	}
+	f := nethttp.Flags{
+		HeaderMap:  map[string]string{},
+		URL:        srv.URL + "/foo?bar=baz",
+		Method:     http.MethodGet,
+		User:       "user:pass",
+		AuthDigest: true,
+	}
+	j, err := nethttp.NewJobProducer(f, lf)
+	require.NoError(t, err)
+
+	require.NoError(t, loadgen.Run(lf, j))
+	assert.Equal(t, map[string]int{"200": 10}, j.RequestCounts())
+	assert.Equal(t, int64(1), atomic.LoadInt64(&challenges))
+}
+
+func TestNewJobProducer_digestPerConn(t *testing.T) {
+	var (
+		mu         sync.Mutex
+		nonces     = map[string]int{} // Last nonce count by nonce.
+		conns      = map[string]bool{}
+		challenges int
+		rejected   int
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		conns[r.RemoteAddr] = true
+
+		params := map[string]string{}
+
+		for _, p := range strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), "Digest "), ", ") {
+			k, v, _ := strings.Cut(p, "=")
+			params[k] = strings.Trim(v, `"`)
+		}
+
+		// Server enforces strictly increasing nonce count.
+		nc, err := strconv.ParseInt(params["nc"], 16, 64)
+		if last, ok := nonces[params["nonce"]]; ok && err == nil && int(nc) == last+1 {
+			nonces[params["nonce"]] = int(nc)
+
+			return
+		}
+
+		if params["nonce"] != "" {
+			rejected++
+		}
+
+		challenges++
+		nonce := "n" + strconv.Itoa(challenges)
+		nonces[nonce] = 0
+
+		w.Header().Set("WWW-Authenticate", `Digest realm="test", qop="auth", nonce="`+nonce+`"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	lf := loadgen.Flags{
+		Number:       100,
+		Concurrency:  4,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       bytes.NewBuffer(nil),
+	}
+	f := nethttp.Flags{
+		HeaderMap:  map[string]string{},
+		URL:        srv.URL,
+		Method:     http.MethodGet,
+		User:       "user:pass",
+		AuthDigest: true,
+	}
+	j, err := nethttp.NewJobProducer(f, lf)
+	require.NoError(t, err)
+
+	require.NoError(t, loadgen.Run(lf, j))
+	assert.Equal(t, map[string]int{"200": 100}, j.RequestCounts())
+
+	// Connections have own challenges and never send out of order nonce count.
+	assert.Equal(t, 0, rejected)
+	assert.LessOrEqual(t, challenges, 2*len(conns))
+}
+
+func TestNewJobProducer_anyAuthHeader(t *testing.T) {
+	var hits int64
+
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		assert.Equal(t, "Bearer foo", r.Header.Get("Authorization"))
+	}))
+	defer srv.Close()
+
+	lf := loadgen.Flags{
+		Number:       5,
+		Concurrency:  1,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       bytes.NewBuffer(nil),
+	}
+	f := nethttp.Flags{
+		HeaderMap: map[string]string{"Authorization": "Bearer foo"},
+		URL:       srv.URL,
+		Method:    http.MethodGet,
+		User:      "user:pass",
+		AuthAny:   true,
+	}
+	j, err := nethttp.NewJobProducer(f, lf)
+	require.NoError(t, err)
+
+	// Header provided by user is kept when server does not challenge.
+	require.NoError(t, loadgen.Run(lf, j))
+	assert.Equal(t, map[string]int{"200": 5}, j.RequestCounts())
+	assert.Equal(t, int64(5), atomic.LoadInt64(&hits))
+}
+
+func TestNewJobProducer_sigV4(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"),
+			"AWS4-HMAC-SHA256 Credential=AKID/"), r.Header.Get("Authorization"))
+		assert.Contains(t, r.Header.Get("Authorization"), "/eu-west-1/execute-api/aws4_request")
+		assert.NotEmpty(t, r.Header.Get("X-Amz-Date"))
+
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "foo", string(body))
+	}))
+	defer srv.Close()
+
+	lf := loadgen.Flags{
+		Number:       5,
+		Concurrency:  1,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       bytes.NewBuffer(nil),
+	}
+	f := nethttp.Flags{
+		HeaderMap: map[string]string{},
+		URL:       srv.URL,
+		Method:    http.MethodPost,
+		Body:      "foo",
+		User:      "AKID:SECRET",
+		AWSSigV4:  "aws:amz:eu-west-1:execute-api",
+	}
+	j, err := nethttp.NewJobProducer(f, lf)
+	require.NoError(t, err)
+
+	require.NoError(t, loadgen.Run(lf, j))
+	assert.Equal(t, map[string]int{"200": 5}, j.RequestCounts())
+}
+
+func TestNewJobProducer_compressed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "gzip", r.Header.Get("Accept-Encoding"))
+
+		w.Header().Set("Content-Encoding", "gzip")
+
+		gw := gzip.NewWriter(w)
+		_, err := gw.Write([]byte(strings.Repeat("hello ", 1000)))
+		require.NoError(t, err)
+		require.NoError(t, gw.Close())
+	}))
+	defer srv.Close()
+
+	out := bytes.NewBuffer(nil)
+
+	lf := loadgen.Flags{
+		Number:       10,
+		Concurrency:  2,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       out,
+	}
+	f := nethttp.Flags{
+		HeaderMap:  map[string]string{"Accept-Encoding": "gzip"},
+		URL:        srv.URL,
+		Method:     http.MethodGet,
+		Compressed: true,
+	}
+	j, err := nethttp.NewJobProducer(f, lf)
+	require.NoError(t, err)
+
+	require.NoError(t, loadgen.Run(lf, j))
+	assert.Equal(t, map[string]int{"200": 10}, j.RequestCounts())
+	assert.Contains(t, out.String(), "[gzip] 10, wire ")
+	assert.Contains(t, out.String(), "decoded 58.6KB (5.9KB avg)")
+	assert.Contains(t, out.String(), "hello hello")
+	assert.NotContains(t, out.String(), "<gzip-encoded-content>")
+}
+
+func TestNewJobProducer_h2Conns(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		remotes = map[string]int{}
+	)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		require.Equal(t, 2, r.ProtoMajor)
+
+		mu.Lock()
+		remotes[r.RemoteAddr]++
+		mu.Unlock()
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+
+	defer srv.Close()
+
+	out := bytes.NewBuffer(nil)
+
+	lf := loadgen.Flags{
+		Number:       30,
+		Concurrency:  5,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       out,
+	}
+	f := nethttp.Flags{
+		HeaderMap: map[string]string{},
+		URL:       srv.URL,
+		Method:    http.MethodGet,
+		HTTP2:     true,
+		H2Conns:   3,
+	}
+	j, err := nethttp.NewJobProducer(f, lf, func(_ *loadgen.Flags, _ *nethttp.Flags, j loadgen.JobProducer) {
+		j.(*nethttp.JobProducer).PrepareRoundTripper = func(tr http.RoundTripper) http.RoundTripper {
+			tr.(*http2.Transport).TLSClientConfig = srv.Client().Transport.(*http.Transport).TLSClientConfig
+
+			return tr
+		}
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, loadgen.Run(lf, j))
+	assert.Equal(t, map[string]int{"200": 30}, j.RequestCounts())
+	assert.Len(t, remotes, 3)
+	assert.Contains(t, out.String(), "HTTP/2 connections: 3")
+	assert.Contains(t, out.String(), "HTTP/2 GOAWAY received: 0")
+	assert.Contains(t, out.String(), "HTTP/2 streams per connection distribution:")
+}
+
+func TestNewJobProducer_h2ConnsSaturated(t *testing.T) {
+	var inFlight, maxInFlight int64
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+		n := atomic.AddInt64(&inFlight, 1)
+		defer atomic.AddInt64(&inFlight, -1)
+
+		for {
+			m := atomic.LoadInt64(&maxInFlight)
+			if n <= m || atomic.CompareAndSwapInt64(&maxInFlight, m, n) {
+				break
+			}
+		}
+
+		time.Sleep(time.Millisecond)
+	}))
+	require.NoError(t, http2.ConfigureServer(srv.Config, &http2.Server{MaxConcurrentStreams: 1}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+
+	defer srv.Close()
+
+	lf := loadgen.Flags{
+		Number:       30,
+		Concurrency:  6,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       bytes.NewBuffer(nil),
+	}
+	f := nethttp.Flags{
+		HeaderMap: map[string]string{},
+		URL:       srv.URL,
+		Method:    http.MethodGet,
+		HTTP2:     true,
+		H2Conns:   2,
+	}
+	j, err := nethttp.NewJobProducer(f, lf, func(_ *loadgen.Flags, _ *nethttp.Flags, j loadgen.JobProducer) {
+		j.(*nethttp.JobProducer).PrepareRoundTripper = func(tr http.RoundTripper) http.RoundTripper {
+			tr.(*http2.Transport).TLSClientConfig = srv.Client().Transport.(*http.Transport).TLSClientConfig
+
+			return tr
+		}
+	})
+	require.NoError(t, err)
+
+	// Requests wait for available streams of saturated connections.
+	require.NoError(t, loadgen.Run(lf, j))
+	assert.Equal(t, map[string]int{"200": 30}, j.RequestCounts())
+	assert.LessOrEqual(t, atomic.LoadInt64(&maxInFlight), int64(2))
+}
+
+func TestNewJobProducer_http3(t *testing.T) {
+	tlsSrv := httptest.NewTLSServer(nil)
+	tlsSrv.Close()
+
+	srv := &http3.Server{
+		Handler: http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			require.Equal(t, 3, r.ProtoMajor)
+
+			_, _ = rw.Write([]byte("hello"))
+		}),
+		TLSConfig:  http3.ConfigureTLSConfig(&tls.Config{Certificates: tlsSrv.TLS.Certificates}), //nolint:gosec
+		QUICConfig: &quic.Config{Allow0RTT: true},
+	}
+
+	uc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+
+	go func() {
+		_ = srv.Serve(uc)
+	}()
+
+	defer func() {
+		_ = srv.Close()
+	}()
+
+	out := bytes.NewBuffer(nil)
+
+	lf := loadgen.Flags{
+		Number:       10,
+		Concurrency:  1,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       out,
+	}
+	f := nethttp.Flags{
+		HeaderMap:   map[string]string{},
+		URL:         "https://" + uc.LocalAddr().String() + "/",
+		Method:      http.MethodGet,
+		HTTP3:       true,
+		Insecure:    true,
+		NoKeepalive: true,
+		QUIC0RTT:    true,
+	}
+	j, err := nethttp.NewJobProducer(f, lf)
+	require.NoError(t, err)
+
+	require.NoError(t, loadgen.Run(lf, j))
+	assert.Equal(t, map[string]int{"200": 10}, j.RequestCounts())
+	assert.Contains(t, out.String(), "QUIC connections: 10 0-RTT accepted: ")
+	assert.NotContains(t, out.String(), "0-RTT accepted: 0\n")
+	assert.Contains(t, out.String(), "QUIC handshake latency distribution in ms:")
+	assert.Contains(t, out.String(), "Bytes read")
+	assert.Contains(t, out.String(), "[HTTP/3.0 200]")
+
+	// QUIC transport is closed with producer.
+	require.NoError(t, j.Close())
+
+	_, err = j.Job(0)
+	require.Error(t, err)
+}
+
+func TestNewJobProducer_http3_verify(t *testing.T) {
+	tlsSrv := httptest.NewTLSServer(nil)
+	tlsSrv.Close()
+
+	srv := &http3.Server{
+		Handler:   http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}),
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{Certificates: tlsSrv.TLS.Certificates}), //nolint:gosec
+	}
+
+	uc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+
+	go func() {
+		_ = srv.Serve(uc)
+	}()
+
+	defer func() {
+		_ = srv.Close()
+	}()
+
+	f := nethttp.Flags{
+		HeaderMap: map[string]string{},
+		URL:       "https://" + uc.LocalAddr().String() + "/",
+		Method:    http.MethodGet,
+		HTTP3:     true,
+	}
+	j, err := nethttp.NewJobProducer(f, loadgen.Flags{})
+	require.NoError(t, err)
+
+	_, err = j.Job(0)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "certificate")
+	require.NoError(t, j.Close())
+}
+
+func TestNewJobProducer_tlsResume(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+	defer srv.Close()
+
+	out := bytes.NewBuffer(nil)
+
+	lf := loadgen.Flags{
+		Number:       10,
+		Concurrency:  1,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       out,
+	}
+	f := nethttp.Flags{
+		HeaderMap:   map[string]string{},
+		URL:         srv.URL,
+		Method:      http.MethodGet,
+		NoKeepalive: true,
+		Insecure:    true,
+		TLSResume:   true,
+	}
+	j, err := nethttp.NewJobProducer(f, lf)
+	require.NoError(t, err)
+
+	require.NoError(t, loadgen.Run(lf, j))
+	assert.Equal(t, map[string]int{"200": 10}, j.RequestCounts())
+	assert.Contains(t, out.String(), "TLS handshakes by version, cipher suite and ALPN:\n[TLS 1.3, ")
+	assert.Contains(t, out.String(), "full 1, resumed 9")
+	assert.Contains(t, out.String(), "Full TLS handshake latency distribution in ms:")
+	assert.Contains(t, out.String(), "Resumed TLS handshake latency distribution in ms:")
+}
+
+func TestNewJobProducer_connReuse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+	defer srv.Close()
+
+	out := bytes.NewBuffer(nil)
+
+	lf := loadgen.Flags{
+		Number:       20,
+		Concurrency:  1,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       out,
+	}
+	f := nethttp.Flags{
+		HeaderMap: map[string]string{},
+		URL:       srv.URL,
+		Method:    http.MethodGet,
+	}
+	j, err := nethttp.NewJobProducer(f, lf)
+	require.NoError(t, err)
+
+	require.NoError(t, loadgen.Run(lf, j))
+	assert.Contains(t, out.String(), "Connections: 1 opened, 19 reused (95.00% reuse ratio), 19 reused idle")
+	assert.Contains(t, out.String(), "Wait for connection latency distribution in ms:")
+	assert.Contains(t, out.String(), "Idle time of reused connections distribution in ms:")
+}
+
+func TestNewJobProducer_noKeepalive(t *testing.T) {
+	for _, h2 := range []bool{false, true} {
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+		srv.EnableHTTP2 = h2
+		srv.StartTLS()
+
+		out := bytes.NewBuffer(nil)
+
+		lf := loadgen.Flags{
+			Number:       10,
+			Concurrency:  2,
+			Duration:     time.Minute,
+			SlowResponse: time.Second,
+			Output:       out,
+		}
+		f := nethttp.Flags{
+			HeaderMap:   map[string]string{},
+			URL:         srv.URL,
+			Method:      http.MethodGet,
+			HTTP2:       h2,
+			NoKeepalive: true,
+			Insecure:    true,
+		}
+		j, err := nethttp.NewJobProducer(f, lf)
+		require.NoError(t, err)
+
+		require.NoError(t, loadgen.Run(lf, j))
+		assert.Equal(t, map[string]int{"200": 10}, j.RequestCounts())
+		assert.Contains(t, out.String(), "Connections: 10 opened, 0 reused")
+
+		srv.Close()
+	}
+}
+
+func TestNewJobProducer_collectHeader(t *testing.T) {
+	var cnt int64
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		if atomic.AddInt64(&cnt, 1)%4 == 0 {
+			rw.Header().Set("X-Cache", "MISS")
+		} else {
+			rw.Header().Set("X-Cache", "HIT")
+		}
+
+		rw.Header().Set("X-Upstream-Time", "0.025")
+	}))
+	defer srv.Close()
+
+	out := bytes.NewBuffer(nil)
+
+	lf := loadgen.Flags{
+		Number:       20,
+		Concurrency:  2,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       out,
+	}
+	f := nethttp.Flags{
+		HeaderMap:            map[string]string{},
+		URL:                  srv.URL,
+		Method:               http.MethodGet,
+		CollectHeader:        []string{"x-cache", "X-Missing"},
+		CollectHeaderLatency: []string{"X-Upstream-Time:s"},
+	}
+	j, err := nethttp.NewJobProducer(f, lf)
+	require.NoError(t, err)
+
+	require.NoError(t, loadgen.Run(lf, j))
+	assert.Contains(t, out.String(), "Responses by X-Cache header value:\n[HIT] 15\n[MISS] 5\n")
+	assert.Contains(t, out.String(), "Responses by X-Missing header value:\n[<none>] 20\n")
+	assert.Contains(t, out.String(), "X-Upstream-Time latency percentiles:\n99%: 25.00ms")
+	assert.Contains(t, out.String(), "X-Upstream-Time latency distribution in ms:")
+
+	f.CollectHeaderLatency = []string{"X-Upstream-Time:min"}
+	_, err = nethttp.NewJobProducer(f, lf)
+	require.Error(t, err)
+}
+
+func TestNewJobProducer_serverTiming(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Add("Server-Timing", `db;dur=12.5;desc="Query, cached", cache;desc=miss`)
+		rw.Header().Add("Server-Timing", "app;dur=30")
+	}))
+	defer srv.Close()
+
+	out := bytes.NewBuffer(nil)
+
+	lf := loadgen.Flags{
+		Number:       10,
+		Concurrency:  2,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       out,
+	}
+	f := nethttp.Flags{
+		HeaderMap: map[string]string{},
+		URL:       srv.URL,
+		Method:    http.MethodGet,
+	}
+	j, err := nethttp.NewJobProducer(f, lf)
+	require.NoError(t, err)
+
+	require.NoError(t, loadgen.Run(lf, j))
+	assert.Contains(t, out.String(), "Server-Timing app percentiles:\n99%: 30.00ms")
+	assert.Contains(t, out.String(), "Server-Timing db percentiles:\n99%: 12.50ms")
+	assert.Contains(t, out.String(), "Server-Timing db distribution in ms:")
+	assert.NotContains(t, out.String(), "Server-Timing cache")
+}
+
+func TestNewJobProducer_sampleDir(t *testing.T) {
+	var cnt int64
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		if atomic.AddInt64(&cnt, 1)%2 == 0 {
+			rw.WriteHeader(http.StatusInternalServerError)
+			_, _ = rw.Write([]byte("something went wrong"))
+
+			return
+		}
+
+		_, _ = rw.Write([]byte("hello world"))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	out := bytes.NewBuffer(nil)
+
+	lf := loadgen.Flags{
+		Number:       10,
+		Concurrency:  2,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       out,
+	}
+	f := nethttp.Flags{
+		HeaderMap:   map[string]string{"X-Foo": "bar"},
+		URL:         srv.URL + "/foo",
+		Method:      http.MethodPost,
+		Body:        "baz",
+		SampleDir:   dir,
+		SampleCount: 2,
+		SampleSize:  5,
+	}
+	j, err := nethttp.NewJobProducer(f, lf)
+	require.NoError(t, err)
+
+	require.NoError(t, loadgen.Run(lf, j))
+	assert.Contains(t, out.String(), "Samples directory: "+dir)
+	assert.Contains(t, out.String(), "\nhello...\n")
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+	require.NoError(t, err)
+
+	names := make([]string, 0, len(files))
+	for _, f := range files {
+		names = append(names, filepath.Base(f))
+	}
+
+	assert.ElementsMatch(t, []string{"200-1.txt", "200-2.txt", "500-1.txt", "500-2.txt", "slowest.txt"}, names)
+
+	sample, err := os.ReadFile(filepath.Join(dir, "500-1.txt"))
+	require.NoError(t, err)
+	assert.Contains(t, string(sample), "POST /foo HTTP/1.1\r\n")
+	assert.Contains(t, string(sample), "X-Foo: bar\r\n")
+	assert.Contains(t, string(sample), "\r\n\r\nbaz\n")
+	assert.Contains(t, string(sample), "HTTP/1.1 500 Internal Server Error\r\n")
+	assert.True(t, strings.HasSuffix(string(sample), "\r\n\r\nsomet"))
+
+	// Failed requests are sampled too.
+	f.URL = "http://" + srv.Listener.Addr().String() + "0/"
+	j, err = nethttp.NewJobProducer(f, lf)
+	require.NoError(t, err)
+
+	_, jobErr := j.Job(0)
+	require.Error(t, jobErr)
+
+	sample, err = os.ReadFile(filepath.Join(dir, "error-1.txt"))
+	require.NoError(t, err)
+	assert.Contains(t, string(sample), jobErr.Error())
+}
+
+func TestNewJobProducer_slowest(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("X-Request-Id") {
+		case "req-3":
+			time.Sleep(100 * time.Millisecond)
+		case "req-7":
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		rw.Header().Set("X-Foo", "bar")
+		_, _ = rw.Write([]byte("hello world"))
+	}))
+	defer srv.Close()
+
+	file := filepath.Join(t.TempDir(), "slowest.json")
+	out := bytes.NewBuffer(nil)
+
+	lf := loadgen.Flags{
+		Number:       10,
+		Concurrency:  2,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       out,
+	}
+	f := nethttp.Flags{
+		HeaderMap:    map[string]string{},
+		URL:          srv.URL,
+		Method:       http.MethodGet,
+		SlowestCount: 2,
+		SlowestJSON:  file,
+	}
+	j, err := nethttp.NewJobProducer(f, lf)
+	require.NoError(t, err)
+
+	j.PrepareRequest = func(i int, req *http.Request) error {
+		req.Header.Set("X-Request-Id", "req-"+strconv.Itoa(i))
+
+		return nil
+	}
+
+	require.NoError(t, loadgen.Run(lf, j))
+	assert.Regexp(t, `Slowest requests:\n\[200\] 1\d\d\.\d\dms #3 at [\d:.]+, .*ttfb 1\d\d\.\d\dms, X-Request-Id: req-3\n`+
+		`\[200\] \d+\.\d\dms #7 at [\d:.]+, .*X-Request-Id: req-7\n\n`, out.String())
+	assert.NotContains(t, out.String(), "Slowest requests exported to")
+
+	require.NoError(t, j.Close())
+	assert.Contains(t, out.String(), "Slowest requests exported to "+file+"\n")
+
+	b, err := os.ReadFile(file)
+	require.NoError(t, err)
+
+	var slowest []nethttp.SlowRequest
+
+	require.NoError(t, json.Unmarshal(b, &slowest))
+	require.Len(t, slowest, 2)
+	assert.Equal(t, 3, slowest[0].Index)
+	assert.Equal(t, 7, slowest[1].Index)
+	assert.Equal(t, "req-3", slowest[0].RequestID)
+	assert.Equal(t, http.StatusOK, slowest[0].Status)
+	assert.Equal(t, "bar", slowest[0].Header.Get("X-Foo"))
+	assert.Greater(t, slowest[0].Phases.TTFB, 100.0)
+	assert.Greater(t, slowest[0].LatencyMS, slowest[1].LatencyMS)
+}
+
+func TestNewJobProducer_slowestPhases(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	file := filepath.Join(t.TempDir(), "slowest.json")
+
+	lf := loadgen.Flags{
+		Number:       1,
+		Concurrency:  1,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       bytes.NewBuffer(nil),
+	}
+	f := nethttp.Flags{
+		HeaderMap:    map[string]string{},
+		URL:          srv.URL,
+		Method:       http.MethodGet,
+		SlowestCount: 1,
+		SlowestJSON:  file,
+	}
+	j, err := nethttp.NewJobProducer(f, lf, func(_ *loadgen.Flags, _ *nethttp.Flags, j loadgen.JobProducer) {
+		j.(*nethttp.JobProducer).PrepareRoundTripper = func(tr http.RoundTripper) http.RoundTripper {
+			ht := tr.(*http.Transport)
+			dial := ht.DialContext
+
+			// Slow dial is a part of connection wait.
+			ht.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+				time.Sleep(50 * time.Millisecond)
+
+				return dial(ctx, network, addr)
+			}
+
+			return ht
+		}
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, loadgen.Run(lf, j))
+	require.NoError(t, j.Close())
+
+	b, err := os.ReadFile(file)
+	require.NoError(t, err)
+
+	var slowest []nethttp.SlowRequest
+
+	require.NoError(t, json.Unmarshal(b, &slowest))
+	require.Len(t, slowest, 1)
+
+	// Write starts with established connection and TTFB starts with written request.
+	ph := slowest[0].Phases
+	assert.GreaterOrEqual(t, ph.ConnWait, 50.0)
+	assert.Less(t, ph.Write, 50.0)
+	assert.GreaterOrEqual(t, ph.TTFB, 20.0)
+	assert.Less(t, ph.TTFB, 50.0)
+}
+
+func TestNewJobProducer_slowestFailed(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	file := filepath.Join(t.TempDir(), "slowest.json")
+	out := bytes.NewBuffer(nil)
+
+	lf := loadgen.Flags{
+		Number:       5,
+		Concurrency:  1,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       out,
+	}
+	f := nethttp.Flags{
+		HeaderMap:   map[string]string{},
+		URL:         "http://" + l.Addr().String() + "/",
+		Method:      http.MethodGet,
+		SlowestJSON: file,
+	}
+	j, err := nethttp.NewJobProducer(f, lf)
+	require.NoError(t, err)
+
+	require.EqualError(t, loadgen.Run(lf, j), "all requests failed: dial tcp "+l.Addr().String()+": connect: connection refused")
+	assert.Empty(t, j.RequestCounts())
+
+	require.NoError(t, j.Close())
+	assert.Contains(t, out.String(), "Slowest requests exported to "+file+"\n")
+
+	b, err := os.ReadFile(file)
+	require.NoError(t, err)
+
+	var slowest []nethttp.SlowRequest
+
+	require.NoError(t, json.Unmarshal(b, &slowest))
+	require.Len(t, slowest, 5)
+	assert.Contains(t, slowest[0].Error, "connection refused")
+	assert.Zero(t, slowest[0].Status)
+}
+
+func TestNewJobProducer_traceParent(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		parents = map[string]bool{}
+		ids     = map[string]bool{}
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		parents[r.Header.Get("Traceparent")] = true
+		ids[r.Header.Get("X-Request-Id")] = true
+		mu.Unlock()
+
+		switch r.Header.Get("X-Request-Id") {
+		case "test-run-3":
+			time.Sleep(100 * time.Millisecond)
+		case "test-run-7":
+			conn, _, err := rw.(http.Hijacker).Hijack()
+			if err == nil {
+				_ = conn.Close()
+			}
+		}
+	}))
+	defer srv.Close()
+
+	out := bytes.NewBuffer(nil)
+
+	lf := loadgen.Flags{
+		Number:       10,
+		Concurrency:  2,
+		Duration:     time.Minute,
+		SlowResponse: 50 * time.Millisecond,
+		Output:       out,
+	}
+	f := nethttp.Flags{
+		HeaderMap:   map[string]string{},
+		URL:         srv.URL,
+		Method:      http.MethodGet,
+		TraceParent: true,
+		RequestID:   true,
+		RunID:       "test-run",
+	}
+	j, err := nethttp.NewJobProducer(f, lf)
+	require.NoError(t, err)
+
+	require.NoError(t, loadgen.Run(lf, j))
+
+	assert.Len(t, parents, 10)
+	assert.Len(t, ids, 10)
+	assert.True(t, ids["test-run-0"])
+
+	assert.Contains(t, out.String(), "Run ID: test-run\n")
+
+	m := regexp.MustCompile(`Trace ID prefix: ([0-9a-f]{16})\n`).FindStringSubmatch(out.String())
+	require.Len(t, m, 2)
+
+	prefix := m[1]
+
+	assert.True(t, parents["00-"+prefix+"0000000000000003-0000000000000004-01"])
+	assert.Regexp(t, `Trace IDs of requests with latency more than 50ms \(1\):\n#3 `+prefix+`0000000000000003 1\d\dms\n`, out.String())
+	assert.Regexp(t, `Trace IDs of failed requests \(1\):\n#7 `+prefix+`0000000000000007 .*EOF\n`, out.String())
+}
+
+func TestNewJobProducer_traceFailed(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	out := bytes.NewBuffer(nil)
+
+	lf := loadgen.Flags{
+		Number:       3,
+		Concurrency:  1,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       out,
+	}
+	f := nethttp.Flags{
+		HeaderMap: map[string]string{},
+		URL:       "http://" + l.Addr().String() + "/",
+		Method:    http.MethodGet,
+		RequestID: true,
+		RunID:     "test-run",
+	}
+	j, err := nethttp.NewJobProducer(f, lf)
+	require.NoError(t, err)
+
+	require.Error(t, loadgen.Run(lf, j))
+	assert.Contains(t, out.String(), "Run ID: test-run\n")
+	assert.Regexp(t, `Trace IDs of failed requests \(3\):\n#0 test-run-0 .+\n#1 test-run-1 .+\n#2 test-run-2 .+\n`, out.String())
+}
+
+func TestNewJobProducer_otlp(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		parents = map[string]bool{}
+		spans   []map[string]any
+	)
+
+	collector := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+
+		var req struct {
+			ResourceSpans []struct {
+				Resource struct {
+					Attributes []map[string]any `json:"attributes"`
+				} `json:"resource"`
+				ScopeSpans []struct {
+					Spans []map[string]any `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Len(t, req.ResourceSpans, 1)
+		assert.Equal(t, "test", req.ResourceSpans[0].Resource.Attributes[0]["value"].(map[string]any)["stringValue"])
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		for _, ss := range req.ResourceSpans[0].ScopeSpans {
+			spans = append(spans, ss.Spans...)
+		}
+	}))
+	defer collector.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		parents[r.Header.Get("Traceparent")] = true
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	out := bytes.NewBuffer(nil)
+
+	lf := loadgen.Flags{
+		Number:       20,
+		Concurrency:  2,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       out,
+	}
+	f := nethttp.Flags{
+		HeaderMap:       map[string]string{},
+		URL:             srv.URL,
+		Method:          http.MethodGet,
+		RunID:           "test-run",
+		OTLPEndpoint:    collector.URL,
+		OTLPServiceName: "test",
+		OTLPSampleRatio: 0.5,
+	}
+	j, err := nethttp.NewJobProducer(f, lf)
+	require.NoError(t, err)
+
+	require.NoError(t, loadgen.Run(lf, j))
+	assert.NotContains(t, out.String(), "OTLP spans exported to")
+
+	// Pending spans are flushed on close.
+	require.NoError(t, j.Close())
+
+	roots := map[string]map[string]any{}
+	children := map[string][]string{}
+	phases := map[string]map[string]any{} // Phase spans by parent and name.
+
+	for _, s := range spans {
+		if p, ok := s["parentSpanId"].(string); ok {
+			children[s["traceId"].(string)+"-"+p] = append(children[s["traceId"].(string)+"-"+p], s["name"].(string))
+			phases[s["traceId"].(string)+"-"+p+"-"+s["name"].(string)] = s
+		} else {
+			roots[s["traceId"].(string)+"-"+s["spanId"].(string)] = s
+		}
+	}
+
+	sampled := 0
+
+	for tp := range parents {
+		if strings.HasSuffix(tp, "-00") {
+			assert.NotContains(t, roots, tp[3:len(tp)-3])
+
+			continue
+		}
+
+		sampled++
+
+		id := tp[3 : len(tp)-3]
+		require.Contains(t, roots, id)
+		assert.Equal(t, "GET", roots[id]["name"])
+		assert.EqualValues(t, 3, roots[id]["kind"])
+		assert.Contains(t, children[id], "write")
+		assert.Contains(t, children[id], "ttfb")
+
+		// TTFB starts when request is written.
+		assert.Equal(t, phases[id+"-write"]["endTimeUnixNano"], phases[id+"-ttfb"]["startTimeUnixNano"])
+	}
+
+	assert.Len(t, parents, 20)
+	assert.Len(t, roots, sampled)
+	assert.Greater(t, sampled, 5)
+	assert.Less(t, sampled, 15)
+	assert.Contains(t, out.String(), fmt.Sprintf("OTLP spans exported to %s/v1/traces: %d\n", collector.URL, len(spans)))
+
+	for _, r := range []float64{0, -1, 1.5} {
+		f.OTLPSampleRatio = r
+		_, err = nethttp.NewJobProducer(f, lf)
+		require.EqualError(t, err, fmt.Sprintf("OTLP sample ratio must be greater than 0 and up to 1, %v given", r))
+	}
+}
+
+func TestNewJobProducer_stream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "text/event-stream")
+
+		fl := rw.(http.Flusher)
+
+		_, _ = rw.Write([]byte(": keep-alive\n\n"))
+		fl.Flush()
+
+		for k := range 3 {
+			time.Sleep(10 * time.Millisecond)
+
+			_, _ = rw.Write([]byte("event: message\ndata: {\"k\":" + strconv.Itoa(k) + "}\n"))
+			fl.Flush()
+
+			_, _ = rw.Write([]byte("data: " + strings.Repeat("a", 5000) + "\n\n"))
+			fl.Flush()
+		}
+
+		// Incomplete event is discarded.
+		_, _ = rw.Write([]byte("data: incomplete\n"))
+	}))
+	defer srv.Close()
+
+	// Comment line is not an event in SSE mode.
+	for mode, events := range map[string]int{nethttp.StreamSSE: 3, nethttp.StreamLines: 11} {
+		t.Run(mode, func(t *testing.T) {
+			out := bytes.NewBuffer(nil)
+
+			lf := loadgen.Flags{
+				Number:       4,
+				Concurrency:  4,
+				Duration:     time.Minute,
+				SlowResponse: time.Second,
+				Output:       out,
+			}
+			f := nethttp.Flags{
+				HeaderMap: map[string]string{},
+				URL:       srv.URL,
+				Method:    http.MethodGet,
+				Stream:    mode,
+			}
+			j, err := nethttp.NewJobProducer(f, lf)
+			require.NoError(t, err)
+
+			require.NoError(t, loadgen.Run(lf, j))
+
+			res := out.String()
+			assert.Contains(t, res, fmt.Sprintf("Streams: 4, events: %d, %d.00 events per stream\n", 4*events, events))
+			assert.Regexp(t, `Time to first event distribution in ms:\n.+ \(4 events\)\n`, res)
+
+			if mode == nethttp.StreamSSE {
+				assert.Regexp(t, `Time to first event percentiles:\n99%: \d+\.\d\dms\n95%: \d+\.\d\dms\n90%: \d+\.\d\dms\n50%: 1\d\.\d\dms\n`, res)
+			}
+			assert.Regexp(t, fmt.Sprintf(`Inter-event latency distribution in ms:\n.+ \(%d events\)\n`, 4*(events-1)), res)
+			assert.Contains(t, res, "Events per stream distribution:\n")
+			assert.Contains(t, res, "Stream duration distribution in ms:\n")
+			assert.Contains(t, res, "\n: keep-alive\n\nevent: message\n")
+		})
+	}
+
+	_, err := nethttp.NewJobProducer(nethttp.Flags{URL: srv.URL, Stream: "foo"}, loadgen.Flags{})
+	require.EqualError(t, err, `unknown stream mode "foo", expected sse, lines or chunks`)
+}
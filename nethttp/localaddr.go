@@ -0,0 +1,254 @@
+package nethttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// localAddrs rotates local addresses of outgoing connections.
+type localAddrs struct {
+	ips    []net.IP
+	portLo int
+	portHi int
+	seq    uint64
+}
+
+func newLocalAddrs(f Flags) (*localAddrs, error) {
+	if f.Interface == "" && f.LocalPort == "" && len(f.SourceIPs) == 0 {
+		return nil, nil //nolint:nilnil // Nil value disables local address binding.
+	}
+
+	la := &localAddrs{}
+
+	for _, s := range f.SourceIPs {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid source IP: %q", s)
+		}
+
+		la.ips = append(la.ips, ip)
+	}
+
+	if f.Interface != "" {
+		ips, err := interfaceIPs(f.Interface)
+		if err != nil {
+			return nil, err
+		}
+
+		la.ips = append(la.ips, ips...)
+	}
+
+	if f.LocalPort != "" {
+		lo, hi, found := strings.Cut(f.LocalPort, "-")
+		if !found {
+			hi = lo
+		}
+
+		var err error
+
+		if la.portLo, err = strconv.Atoi(lo); err != nil {
+			return nil, fmt.Errorf("invalid local port range %q: %w", f.LocalPort, err)
+		}
+
+		if la.portHi, err = strconv.Atoi(hi); err != nil {
+			return nil, fmt.Errorf("invalid local port range %q: %w", f.LocalPort, err)
+		}
+
+		if la.portLo <= 0 || la.portHi > 65535 || la.portLo > la.portHi {
+			return nil, fmt.Errorf("invalid local port range %q", f.LocalPort)
+		}
+	}
+
+	return la, nil
+}
+
+// interfaceIPs resolves curl-style interface argument (name, IP or host name) to local IPs.
+func interfaceIPs(name string) ([]net.IP, error) {
+	switch {
+	case strings.HasPrefix(name, "if!"):
+		return ifaceIPs(strings.TrimPrefix(name, "if!"))
+	case strings.HasPrefix(name, "host!"):
+		return hostIPs(strings.TrimPrefix(name, "host!"))
+	}
+
+	if ip := net.ParseIP(name); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	if ips, err := ifaceIPs(name); err == nil {
+		return ips, nil
+	}
+
+	return hostIPs(name)
+}
+
+func ifaceIPs(name string) ([]net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find interface %s: %w", name, err)
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get addresses of interface %s: %w", name, err)
+	}
+
+	ips := make([]net.IP, 0, len(addrs))
+
+	for _, a := range addrs {
+		if ipn, ok := a.(*net.IPNet); ok && !ipn.IP.IsLinkLocalUnicast() {
+			ips = append(ips, ipn.IP)
+		}
+	}
+
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no usable addresses on interface %s", name)
+	}
+
+	return ips, nil
+}
+
+func hostIPs(host string) ([]net.IP, error) {
+	addrs, err := net.LookupIP(host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve interface host %s: %w", host, err)
+	}
+
+	return addrs, nil
+}
+
+// String returns description of local addresses.
+func (la *localAddrs) String() string {
+	res := make([]string, 0, len(la.ips))
+	for _, ip := range la.ips {
+		res = append(res, ip.String())
+	}
+
+	s := strings.Join(res, ",")
+	if s == "" {
+		s = "*"
+	}
+
+	if la.portLo != 0 {
+		s += ", ports " + strconv.Itoa(la.portLo) + "-" + strconv.Itoa(la.portHi)
+	}
+
+	return s
+}
+
+// dial connects with next local address, it tries other ports of range if local port is busy.
+func (la *localAddrs) dial(ctx context.Context, d *net.Dialer, network, addr string) (net.Conn, error) {
+	ips, network, addr, err := la.sameFamily(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	attempts := 1
+	if la.portLo != 0 {
+		attempts = la.portHi - la.portLo + 1
+	}
+
+	for range attempts {
+		n := atomic.AddUint64(&la.seq, 1) - 1
+		local := &net.TCPAddr{}
+
+		// IP and port advance independently to use all pairs.
+		if len(ips) > 0 {
+			local.IP = ips[n%uint64(len(ips))]
+			n /= uint64(len(ips))
+		}
+
+		if la.portLo != 0 {
+			local.Port = la.portLo + int(n%uint64(la.portHi-la.portLo+1)) //nolint:gosec // Port range is small.
+		}
+
+		ld := *d
+		ld.LocalAddr = local
+
+		var c net.Conn
+
+		c, err = ld.DialContext(ctx, network, addr)
+		if err == nil || !errors.Is(err, syscall.EADDRINUSE) {
+			return c, err
+		}
+	}
+
+	return nil, err
+}
+
+// sameFamily returns local IPs of the address family of remote host and network narrowed to that family.
+//
+// If host name has to be resolved to choose family, address is replaced with resolved IP to avoid second lookup.
+func (la *localAddrs) sameFamily(ctx context.Context, network, addr string) ([]net.IP, string, string, error) {
+	var v4, v6 []net.IP
+
+	for _, ip := range la.ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+
+	if len(v4) == 0 && len(v6) == 0 {
+		return nil, network, addr, nil
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	ipv4 := len(v6) == 0
+
+	switch ip := net.ParseIP(host); {
+	case ip != nil:
+		ipv4 = ip.To4() != nil
+	case len(v4) > 0 && len(v6) > 0:
+		// Host name can resolve to both families, IPv4 is preferred if available.
+		// Lookup with request context is traced as DNS phase.
+		resolved, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+		if err != nil {
+			return nil, "", "", err
+		}
+
+		ip := resolved[0]
+
+		for _, r := range resolved {
+			if r.To4() != nil {
+				ip = r
+
+				break
+			}
+		}
+
+		ipv4 = ip.To4() != nil
+		addr = net.JoinHostPort(ip.String(), port)
+	}
+
+	ips, suffix := v4, "4"
+	if !ipv4 {
+		ips, suffix = v6, "6"
+	}
+
+	if len(ips) == 0 {
+		return nil, "", "", fmt.Errorf("no IPv%s source address to connect to %s", suffix, addr)
+	}
+
+	if network == "tcp" || network == "udp" {
+		network += suffix
+	}
+
+	return ips, network, addr, nil
+}
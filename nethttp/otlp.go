@@ -0,0 +1,329 @@
+package nethttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	otlpBatchSize     = 512
+	otlpFlushInterval = time.Second
+	otlpMaxInFlight   = 4
+
+	otlpSpanKindInternal = 1
+	otlpSpanKindClient   = 3
+	otlpStatusError      = 2
+)
+
+// RequestSpan describes a request to export as a client span with child spans of phases.
+type RequestSpan struct {
+	Index  int
+	Method string
+	URL    string
+	Start  time.Time
+	End    time.Time
+	Status int
+	Err    error
+	Phases []PhaseSpan
+}
+
+// PhaseSpan describes a phase of request.
+type PhaseSpan struct {
+	Name  string
+	Start time.Time
+	End   time.Time
+}
+
+// OTLPExporter sends spans of requests to OTLP/HTTP endpoint in JSON encoding.
+//
+// Spans are sent in batches in background, batches are dropped if endpoint is not keeping up.
+type OTLPExporter struct {
+	endpoint string
+	service  string
+	client   *http.Client
+	traces   *TraceContext
+
+	mu        sync.Mutex
+	spans     []otlpSpan
+	lastFlush time.Time
+	lastErr   error
+
+	inFlight chan struct{}
+	wg       sync.WaitGroup
+
+	exported int64
+	dropped  int64
+	failed   int64
+}
+
+// NewOTLPExporter creates exporter from OTLPEndpoint and OTLPServiceName flags, it returns nil if OTLPEndpoint is empty.
+//
+// If endpoint has no path, default /v1/traces is used.
+func NewOTLPExporter(f Flags, traces *TraceContext) (*OTLPExporter, error) {
+	if f.OTLPEndpoint == "" {
+		return nil, nil //nolint:nilnil // Nil value disables export.
+	}
+
+	// Negated check also rejects NaN.
+	if !(f.OTLPSampleRatio > 0 && f.OTLPSampleRatio <= 1) {
+		return nil, fmt.Errorf("OTLP sample ratio must be greater than 0 and up to 1, %v given", f.OTLPSampleRatio)
+	}
+
+	u, err := url.Parse(f.OTLPEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse OTLP endpoint: %w", err)
+	}
+
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+
+	e := &OTLPExporter{
+		endpoint:  u.String(),
+		service:   f.OTLPServiceName,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		traces:    traces,
+		lastFlush: time.Now(),
+		inFlight:  make(chan struct{}, otlpMaxInFlight),
+	}
+
+	if e.service == "" {
+		e.service = "plt"
+	}
+
+	return e, nil
+}
+
+// Add schedules export of a sampled request.
+func (e *OTLPExporter) Add(r RequestSpan) {
+	if !e.traces.Sampled(r.Index) {
+		return
+	}
+
+	traceID := e.traces.TraceID(r.Index)
+	spanID := e.traces.SpanID(r.Index)
+
+	root := otlpSpan{
+		TraceID: traceID,
+		SpanID:  spanID,
+		Name:    r.Method,
+		Kind:    otlpSpanKindClient,
+		Start:   r.Start.UnixNano(),
+		End:     r.End.UnixNano(),
+		Attributes: []otlpAttribute{
+			stringAttribute("http.request.method", r.Method),
+			stringAttribute("url.full", r.URL),
+			intAttribute("plt.job.index", int64(r.Index)),
+		},
+	}
+
+	if r.Status != 0 {
+		root.Attributes = append(root.Attributes, intAttribute("http.response.status_code", int64(r.Status)))
+	}
+
+	if r.Err != nil {
+		root.Status = &otlpStatus{Code: otlpStatusError, Message: r.Err.Error()}
+	} else if r.Status >= http.StatusInternalServerError {
+		root.Status = &otlpStatus{Code: otlpStatusError}
+	}
+
+	spans := make([]otlpSpan, 0, len(r.Phases)+1)
+	spans = append(spans, root)
+
+	for k, p := range r.Phases {
+		if p.Start.IsZero() || p.End.IsZero() {
+			continue
+		}
+
+		spans = append(spans, otlpSpan{
+			TraceID: traceID,
+			// Child span IDs have the high bit set to differ from client span IDs.
+			SpanID:       fmt.Sprintf("%016x", uint64(1)<<63|uint64(k+1)), //nolint:gosec // Phase index is small.
+			ParentSpanID: spanID,
+			Name:         p.Name,
+			Kind:         otlpSpanKindInternal,
+			Start:        p.Start.UnixNano(),
+			End:          p.End.UnixNano(),
+		})
+	}
+
+	e.mu.Lock()
+	e.spans = append(e.spans, spans...)
+
+	var batch []otlpSpan
+
+	if len(e.spans) >= otlpBatchSize || time.Since(e.lastFlush) >= otlpFlushInterval {
+		batch = e.spans
+		e.spans = nil
+		e.lastFlush = time.Now()
+	}
+	e.mu.Unlock()
+
+	if batch == nil {
+		return
+	}
+
+	select {
+	case e.inFlight <- struct{}{}:
+	default:
+		atomic.AddInt64(&e.dropped, int64(len(batch)))
+
+		return
+	}
+
+	e.wg.Add(1)
+
+	go func() {
+		defer func() {
+			<-e.inFlight
+			e.wg.Done()
+		}()
+
+		e.send(batch)
+	}()
+}
+
+// Flush sends pending spans and waits for background exports to finish.
+func (e *OTLPExporter) Flush() {
+	e.mu.Lock()
+	batch := e.spans
+	e.spans = nil
+	e.mu.Unlock()
+
+	if len(batch) > 0 {
+		e.send(batch)
+	}
+
+	e.wg.Wait()
+}
+
+// String renders export report, it should be called after Flush.
+func (e *OTLPExporter) String() string {
+	res := fmt.Sprintf("OTLP spans exported to %s: %d", e.endpoint, atomic.LoadInt64(&e.exported))
+
+	if dropped := atomic.LoadInt64(&e.dropped); dropped > 0 {
+		res += fmt.Sprintf(", dropped: %d", dropped)
+	}
+
+	if failed := atomic.LoadInt64(&e.failed); failed > 0 {
+		e.mu.Lock()
+		res += fmt.Sprintf(", failed: %d, last error: %s", failed, e.lastErr)
+		e.mu.Unlock()
+	}
+
+	return res + "\n\n"
+}
+
+func (e *OTLPExporter) send(spans []otlpSpan) {
+	if err := e.post(spans); err != nil {
+		atomic.AddInt64(&e.failed, int64(len(spans)))
+
+		e.mu.Lock()
+		e.lastErr = err
+		e.mu.Unlock()
+
+		return
+	}
+
+	atomic.AddInt64(&e.exported, int64(len(spans)))
+}
+
+func (e *OTLPExporter) post(spans []otlpSpan) error {
+	body, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttribute{stringAttribute("service.name", e.service)}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/vearutop/plt"},
+			Spans: spans,
+		}},
+	}}})
+	if err != nil {
+		return fmt.Errorf("failed to marshal spans: %w", err)
+	}
+
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to send spans: %w", err)
+	}
+
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected OTLP response status: %s", resp.Status)
+	}
+
+	return nil
+}
+
+// OTLP/HTTP JSON encoding of ExportTraceServiceRequest.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+
+	otlpSpan struct {
+		TraceID      string          `json:"traceId"`
+		SpanID       string          `json:"spanId"`
+		ParentSpanID string          `json:"parentSpanId,omitempty"`
+		Name         string          `json:"name"`
+		Kind         int             `json:"kind"`
+		Start        int64           `json:"startTimeUnixNano,string"`
+		End          int64           `json:"endTimeUnixNano,string"`
+		Attributes   []otlpAttribute `json:"attributes,omitempty"`
+		Status       *otlpStatus     `json:"status,omitempty"`
+	}
+
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+
+	otlpValue struct {
+		StringValue *string `json:"stringValue,omitempty"`
+		IntValue    *string `json:"intValue,omitempty"` // int64 is encoded as string.
+	}
+)
+
+func stringAttribute(k, v string) otlpAttribute {
+	return otlpAttribute{Key: k, Value: otlpValue{StringValue: &v}}
+}
+
+func intAttribute(k string, v int64) otlpAttribute {
+	s := strconv.FormatInt(v, 10)
+
+	return otlpAttribute{Key: k, Value: otlpValue{IntValue: &s}}
+}
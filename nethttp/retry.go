@@ -0,0 +1,144 @@
+package nethttp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// DefaultRetryOnStatus lists transient HTTP statuses that are retried by default, same as in curl.
+var DefaultRetryOnStatus = []int{
+	http.StatusRequestTimeout,
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+const maxRetryBackoff = 10 * time.Minute
+
+// roundTrip sends request and retries it according to retry flags.
+//
+// Returned cancel function releases resources of the final attempt, it must be called after response body is consumed.
+func (j *JobProducer) roundTrip(tr http.RoundTripper, req *http.Request, onRetry func()) (*http.Response, context.CancelFunc, error) {
+	first := time.Now()
+
+	for attempt := 0; ; attempt++ {
+		r := req
+		cancel := context.CancelFunc(func() {})
+
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, cancel, err
+			}
+
+			r = req.Clone(req.Context())
+			r.Body = body
+		}
+
+		if j.f.MaxTime > 0 {
+			var ctx context.Context
+
+			ctx, cancel = context.WithTimeout(r.Context(), j.f.MaxTime)
+			r = r.WithContext(ctx)
+		}
+
+		start := time.Now()
+		resp, err := tr.RoundTrip(r)
+
+		if attempt == 0 {
+			ms := 1000 * time.Since(start).Seconds()
+			j.firstAttemptHist.Add(ms)
+			j.firstAttemptHistPrecise.Add(ms)
+		}
+
+		retry := j.retryable(req, resp, err)
+		delay := j.retryDelay(attempt)
+
+		if attempt >= j.f.Retry || !retry ||
+			(j.f.RetryMaxTime > 0 && time.Since(first)+delay > j.f.RetryMaxTime) {
+			if attempt > 0 {
+				atomic.AddInt64(&j.retriedRequests, 1)
+
+				if retry {
+					atomic.AddInt64(&j.retriesExhausted, 1)
+				}
+			}
+
+			return resp, cancel, err
+		}
+
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+
+		cancel()
+		atomic.AddInt64(&j.retries, 1)
+
+		time.Sleep(delay)
+		onRetry()
+	}
+}
+
+// retryable checks if request attempt has failed with transient problem.
+func (j *JobProducer) retryable(req *http.Request, resp *http.Response, err error) bool {
+	if j.f.Retry <= 0 {
+		return false
+	}
+
+	// Request body can not be sent again.
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+
+	if err != nil {
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			return j.f.RetryConnRefused
+		}
+
+		var te interface{ Timeout() bool }
+
+		return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &te) && te.Timeout())
+	}
+
+	statuses := j.f.RetryOnStatus
+	if len(statuses) == 0 {
+		statuses = DefaultRetryOnStatus
+	}
+
+	return slices.Contains(statuses, resp.StatusCode)
+}
+
+// retryDelay returns fixed delay or exponential backoff with jitter.
+func (j *JobProducer) retryDelay(attempt int) time.Duration {
+	if j.f.RetryDelay > 0 {
+		return j.f.RetryDelay
+	}
+
+	backoff := j.f.RetryBackoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+
+	for range attempt {
+		backoff *= 2
+
+		if backoff >= maxRetryBackoff {
+			backoff = maxRetryBackoff
+
+			break
+		}
+	}
+
+	// Equal jitter keeps at least half of the backoff.
+	return backoff/2 + rand.N(backoff/2+1) //nolint:gosec // Weak random is fine for jitter.
+}
@@ -0,0 +1,135 @@
+package nethttp
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Sampler saves request and response pairs to a directory.
+//
+// Up to count samples are saved for every status code and for failed requests,
+// the slowest request is kept in slowest.txt.
+type Sampler struct {
+	dir   string
+	count int
+	size  int
+
+	mu      sync.Mutex
+	saved   map[string]int
+	slowest time.Duration
+
+	// slowestMu serializes writes of slowest.txt, so that slower sample is not overwritten by a late faster one.
+	slowestMu    sync.Mutex
+	slowestSaved time.Duration
+}
+
+// NewSampler creates sampler from SampleDir, SampleCount and SampleSize flags, it returns nil if SampleDir is empty.
+func NewSampler(f Flags) (*Sampler, error) {
+	if f.SampleDir == "" {
+		return nil, nil //nolint:nilnil // Nil value disables sampling.
+	}
+
+	if err := os.MkdirAll(f.SampleDir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create sample dir: %w", err)
+	}
+
+	s := &Sampler{
+		dir:   f.SampleDir,
+		count: f.SampleCount,
+		size:  f.SampleSize,
+		saved: make(map[string]int),
+	}
+
+	if s.count <= 0 {
+		s.count = 1
+	}
+
+	if s.size <= 0 {
+		s.size = SampleSize
+	}
+
+	return s, nil
+}
+
+// Dir returns sample directory.
+func (s *Sampler) Dir() string {
+	return s.dir
+}
+
+// Size returns maximum number of response body bytes to keep.
+func (s *Sampler) Size() int {
+	return s.size
+}
+
+// Save stores request and response if there are not enough samples of the status code
+// or if the request is the slowest so far.
+//
+// Status 0 denotes failed request, dump is only called when sample is needed.
+func (s *Sampler) Save(status int, latency time.Duration, dump func() []byte) error {
+	kind := "error"
+	if status != 0 {
+		kind = strconv.Itoa(status)
+	}
+
+	s.mu.Lock()
+
+	var name string
+
+	if n := s.saved[kind]; n < s.count {
+		s.saved[kind] = n + 1
+		name = fmt.Sprintf("%s-%d.txt", kind, n+1)
+	}
+
+	slowest := status != 0 && latency > s.slowest
+	if slowest {
+		s.slowest = latency
+	}
+
+	s.mu.Unlock()
+
+	if name == "" && !slowest {
+		return nil
+	}
+
+	// Sample is dumped and written without holding mu to avoid blocking concurrent requests on disk I/O.
+	data := append([]byte(fmt.Sprintf("# Latency: %s\n\n", latency)), dump()...)
+
+	if name != "" {
+		if err := os.WriteFile(filepath.Join(s.dir, name), data, 0o600); err != nil {
+			return fmt.Errorf("failed to save sample: %w", err)
+		}
+	}
+
+	if slowest {
+		s.slowestMu.Lock()
+		defer s.slowestMu.Unlock()
+
+		if latency > s.slowestSaved {
+			s.slowestSaved = latency
+
+			if err := os.WriteFile(filepath.Join(s.dir, "slowest.txt"), data, 0o600); err != nil {
+				return fmt.Errorf("failed to save sample: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// limitedBuffer keeps first bytes written to it.
+type limitedBuffer struct {
+	limit int
+	buf   []byte
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if rest := b.limit - len(b.buf); rest > 0 {
+		b.buf = append(b.buf, p[:min(rest, len(p))]...)
+	}
+
+	return len(p), nil
+}
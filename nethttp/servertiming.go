@@ -0,0 +1,131 @@
+package nethttp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/vearutop/dynhist-go"
+)
+
+// maxServerTimingMetrics limits number of distinct Server-Timing metric names.
+const maxServerTimingMetrics = 50
+
+// serverTiming aggregates durations of Server-Timing metrics, see https://www.w3.org/TR/server-timing/.
+type serverTiming struct {
+	mu      sync.Mutex
+	metrics map[string]*serverTimingMetric
+}
+
+type serverTimingMetric struct {
+	hist    *dynhist.Collector
+	precise *dynhist.Collector
+}
+
+// add parses Server-Timing header values.
+func (s *serverTiming) add(values []string) {
+	for _, v := range values {
+		for _, m := range splitQuoted(v, ',') {
+			params := splitQuoted(m, ';')
+
+			name := strings.TrimSpace(params[0])
+			if name == "" {
+				continue
+			}
+
+			for _, p := range params[1:] {
+				k, val, _ := strings.Cut(p, "=")
+				if !strings.EqualFold(strings.TrimSpace(k), "dur") {
+					continue
+				}
+
+				dur, err := strconv.ParseFloat(strings.Trim(strings.TrimSpace(val), `"`), 64)
+				if err != nil {
+					break
+				}
+
+				if sm := s.metric(name); sm != nil {
+					sm.hist.Add(dur)
+					sm.precise.Add(dur)
+				}
+
+				break
+			}
+		}
+	}
+}
+
+func (s *serverTiming) metric(name string) *serverTimingMetric {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.metrics == nil {
+		s.metrics = make(map[string]*serverTimingMetric)
+	}
+
+	sm := s.metrics[name]
+	if sm == nil {
+		if len(s.metrics) >= maxServerTimingMetrics {
+			return nil
+		}
+
+		sm = &serverTimingMetric{
+			hist:    &dynhist.Collector{BucketsLimit: 10, WeightFunc: dynhist.LatencyWidth},
+			precise: &dynhist.Collector{BucketsLimit: 100, WeightFunc: dynhist.LatencyWidth},
+		}
+		s.metrics[name] = sm
+	}
+
+	return sm
+}
+
+// String renders Server-Timing report.
+func (s *serverTiming) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := ""
+
+	for _, name := range sortedKeys(s.metrics) {
+		sm := s.metrics[name]
+
+		res += "Server-Timing " + name + " percentiles:\n"
+		res += fmt.Sprintf("99%%: %.2fms\n", sm.precise.Percentile(99))
+		res += fmt.Sprintf("95%%: %.2fms\n", sm.precise.Percentile(95))
+		res += fmt.Sprintf("90%%: %.2fms\n", sm.precise.Percentile(90))
+		res += fmt.Sprintf("50%%: %.2fms\n\n", sm.precise.Percentile(50))
+
+		res += "Server-Timing " + name + " distribution in ms:\n"
+		res += sm.hist.String() + "\n"
+	}
+
+	return res
+}
+
+// splitQuoted splits s by sep outside of double-quoted strings.
+func splitQuoted(s string, sep byte) []string {
+	var (
+		res    []string
+		quoted bool
+		start  int
+	)
+
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if quoted {
+				i++
+			}
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				res = append(res, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(res, s[start:])
+}
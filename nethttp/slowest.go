@@ -0,0 +1,197 @@
+package nethttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SlowestCount is a default number of slowest requests to keep when only SlowestJSON is set.
+const SlowestCount = 10
+
+// SlowRequest describes one of the slowest requests.
+type SlowRequest struct {
+	Index       int         `json:"index"`
+	Start       time.Time   `json:"start"`
+	LatencyMS   float64     `json:"latency_ms"`
+	Phases      Phases      `json:"phases"`
+	Status      int         `json:"status,omitempty"`
+	Error       string      `json:"error,omitempty"`
+	RequestID   string      `json:"request_id,omitempty"`
+	TraceParent string      `json:"traceparent,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+}
+
+// Phases keeps durations of request phases in ms, zero values are omitted (e.g. for reused connection).
+type Phases struct {
+	ConnWait float64 `json:"conn_wait_ms,omitempty"`
+	DNS      float64 `json:"dns_ms,omitempty"`
+	Connect  float64 `json:"connect_ms,omitempty"`
+	TLS      float64 `json:"tls_ms,omitempty"`
+	Write    float64 `json:"write_ms,omitempty"` // From established connection to written request.
+	TTFB     float64 `json:"ttfb_ms,omitempty"`  // From written request to first response byte.
+}
+
+// String renders non-zero phases.
+func (p Phases) String() string {
+	var res []string
+
+	for _, ph := range []struct {
+		name string
+		ms   float64
+	}{
+		{"conn wait", p.ConnWait},
+		{"dns", p.DNS},
+		{"connect", p.Connect},
+		{"tls", p.TLS},
+		{"write", p.Write},
+		{"ttfb", p.TTFB},
+	} {
+		if ph.ms > 0 {
+			res = append(res, fmt.Sprintf("%s %.2fms", ph.name, ph.ms))
+		}
+	}
+
+	return strings.Join(res, ", ")
+}
+
+// Slowest keeps top N slowest requests.
+type Slowest struct {
+	n    int
+	file string
+
+	mu    sync.Mutex
+	items []SlowRequest // Sorted by latency, slowest first.
+}
+
+// NewSlowest creates tracker from SlowestCount and SlowestJSON flags, it returns nil if both are empty.
+func NewSlowest(f Flags) *Slowest {
+	n := f.SlowestCount
+	if n <= 0 {
+		if f.SlowestJSON == "" {
+			return nil
+		}
+
+		n = SlowestCount
+	}
+
+	return &Slowest{n: n, file: f.SlowestJSON}
+}
+
+// Add keeps request if it is among the slowest, build is only called in that case.
+func (s *Slowest) Add(latency time.Duration, build func() SlowRequest) {
+	ms := 1000 * latency.Seconds()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.items) == s.n && s.items[len(s.items)-1].LatencyMS >= ms {
+		return
+	}
+
+	r := build()
+	r.LatencyMS = ms
+
+	pos := sort.Search(len(s.items), func(i int) bool {
+		return s.items[i].LatencyMS < ms
+	})
+
+	if len(s.items) < s.n {
+		s.items = append(s.items, SlowRequest{})
+	}
+
+	copy(s.items[pos+1:], s.items[pos:])
+	s.items[pos] = r
+}
+
+// String renders report.
+func (s *Slowest) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.items) == 0 {
+		return ""
+	}
+
+	res := "Slowest requests:\n"
+
+	for _, r := range s.items {
+		status := "error"
+		if r.Status != 0 {
+			status = fmt.Sprintf("%d", r.Status)
+		}
+
+		res += fmt.Sprintf("[%s] %.2fms #%d at %s", status, r.LatencyMS, r.Index, r.Start.Format("15:04:05.000"))
+
+		if p := r.Phases.String(); p != "" {
+			res += ", " + p
+		}
+
+		if r.RequestID != "" {
+			res += ", X-Request-Id: " + r.RequestID
+		}
+
+		if r.TraceParent != "" {
+			res += ", traceparent: " + r.TraceParent
+		}
+
+		if r.Error != "" {
+			res += ", " + r.Error
+		}
+
+		res += "\n"
+	}
+
+	return res + "\n"
+}
+
+// Export writes requests to SlowestJSON file and reports it to w, it does nothing if file is not configured.
+//
+// File is written even if there are no requests, e.g. when all of them failed before tracking.
+func (s *Slowest) Export(w io.Writer) error {
+	if s.file == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	items := s.items
+	if items == nil {
+		items = []SlowRequest{}
+	}
+
+	b, err := json.MarshalIndent(items, "", " ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal slowest requests: %w", err)
+	}
+
+	if err := os.WriteFile(s.file, append(b, '\n'), 0o600); err != nil {
+		return fmt.Errorf("failed to write slowest requests: %w", err)
+	}
+
+	_, err = fmt.Fprintln(w, "Slowest requests exported to", s.file)
+
+	return err
+}
+
+// TraceIDs returns X-Request-Id and traceparent values from the first getter that has them.
+func TraceIDs(get ...func(string) string) (requestID, traceParent string) {
+	for _, g := range get {
+		if requestID == "" {
+			requestID = g("X-Request-Id")
+		}
+
+		if traceParent == "" {
+			traceParent = g("Traceparent")
+		}
+	}
+
+	return requestID, traceParent
+}
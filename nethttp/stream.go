@@ -0,0 +1,194 @@
+package nethttp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/vearutop/dynhist-go"
+)
+
+// Streaming response modes.
+const (
+	StreamSSE    = "sse"    // Server-Sent Events, separated by blank lines.
+	StreamLines  = "lines"  // Newline-delimited messages, e.g. JSON lines.
+	StreamChunks = "chunks" // Every read from response body.
+)
+
+// streamStats collects event timings of streaming responses.
+type streamStats struct {
+	mode string
+
+	streams int64
+	events  int64
+
+	firstEventHist        *dynhist.Collector
+	firstEventHistPrecise *dynhist.Collector
+	interEventHist        *dynhist.Collector
+	interEventHistPrecise *dynhist.Collector
+	eventsHist            *dynhist.Collector
+	durationHist          *dynhist.Collector
+}
+
+func newStreamStats(mode string) (*streamStats, error) {
+	switch mode {
+	case "":
+		return nil, nil //nolint:nilnil // Nil value disables streaming.
+	case StreamSSE, StreamLines, StreamChunks:
+	default:
+		return nil, fmt.Errorf("unknown stream mode %q, expected %s, %s or %s", mode, StreamSSE, StreamLines, StreamChunks)
+	}
+
+	return &streamStats{
+		mode:                  mode,
+		firstEventHist:        &dynhist.Collector{BucketsLimit: 10, WeightFunc: dynhist.LatencyWidth},
+		firstEventHistPrecise: &dynhist.Collector{BucketsLimit: 100, WeightFunc: dynhist.LatencyWidth},
+		interEventHist:        &dynhist.Collector{BucketsLimit: 10, WeightFunc: dynhist.LatencyWidth},
+		interEventHistPrecise: &dynhist.Collector{BucketsLimit: 100, WeightFunc: dynhist.LatencyWidth},
+		eventsHist:            &dynhist.Collector{BucketsLimit: 10},
+		durationHist:          &dynhist.Collector{BucketsLimit: 10, WeightFunc: dynhist.LatencyWidth},
+	}, nil
+}
+
+// read consumes response body and records time of every event since start.
+func (s *streamStats) read(body io.Reader, start time.Time) error {
+	var (
+		events int
+		last   = start
+	)
+
+	event := func() {
+		now := time.Now()
+		ms := 1000 * now.Sub(last).Seconds()
+
+		if events == 0 {
+			s.firstEventHist.Add(ms)
+			s.firstEventHistPrecise.Add(ms)
+		} else {
+			s.interEventHist.Add(ms)
+			s.interEventHistPrecise.Add(ms)
+		}
+
+		events++
+		last = now
+	}
+
+	var err error
+
+	if s.mode == StreamChunks {
+		buf := make([]byte, 32*1024)
+
+		for {
+			var n int
+
+			n, err = body.Read(buf)
+			if n > 0 {
+				event()
+			}
+
+			if err != nil {
+				break
+			}
+		}
+	} else {
+		err = s.readLines(bufio.NewReader(body), event)
+	}
+
+	atomic.AddInt64(&s.streams, 1)
+	atomic.AddInt64(&s.events, int64(events))
+	s.eventsHist.Add(float64(events))
+	s.durationHist.Add(1000 * time.Since(start).Seconds())
+
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+
+	return err
+}
+
+// readLines calls event for every non-empty line or, in SSE mode, for every blank line that ends an event.
+func (s *streamStats) readLines(r *bufio.Reader, event func()) error {
+	var (
+		fields  bool // SSE event has fields.
+		cont    bool // Line continues after buffer was full.
+		comment bool // SSE line starts with colon.
+	)
+
+	for {
+		line, err := r.ReadSlice('\n')
+		full := errors.Is(err, bufio.ErrBufferFull)
+		blank := !cont && len(bytes.TrimRight(line, "\r\n")) == 0
+
+		if !cont {
+			comment = len(line) > 0 && line[0] == ':'
+		}
+
+		switch {
+		case s.mode == StreamLines:
+			if !full && !blank {
+				event()
+			}
+		case blank:
+			// Incomplete event at the end of stream is discarded.
+			if fields && len(line) > 0 {
+				event()
+			}
+
+			fields = false
+		case !comment:
+			fields = true
+		}
+
+		cont = full
+
+		if err != nil && !full {
+			return err
+		}
+	}
+}
+
+// String renders report.
+func (s *streamStats) String() string {
+	streams := atomic.LoadInt64(&s.streams)
+	if streams == 0 {
+		return ""
+	}
+
+	events := atomic.LoadInt64(&s.events)
+
+	res := fmt.Sprintf("Streams: %d, events: %d, %.2f events per stream\n\n", streams, events, float64(events)/float64(streams))
+
+	if s.firstEventHist.Count > 0 {
+		res += "Time to first event percentiles:\n"
+		res += fmt.Sprintf("99%%: %.2fms\n", s.firstEventHistPrecise.Percentile(99))
+		res += fmt.Sprintf("95%%: %.2fms\n", s.firstEventHistPrecise.Percentile(95))
+		res += fmt.Sprintf("90%%: %.2fms\n", s.firstEventHistPrecise.Percentile(90))
+		res += fmt.Sprintf("50%%: %.2fms\n\n", s.firstEventHistPrecise.Percentile(50))
+
+		res += "Time to first event distribution in ms:\n"
+		res += s.firstEventHist.String() + "\n"
+	}
+
+	if s.interEventHist.Count > 0 {
+		res += "Inter-event latency percentiles:\n"
+		res += fmt.Sprintf("99%%: %.2fms\n", s.interEventHistPrecise.Percentile(99))
+		res += fmt.Sprintf("95%%: %.2fms\n", s.interEventHistPrecise.Percentile(95))
+		res += fmt.Sprintf("90%%: %.2fms\n", s.interEventHistPrecise.Percentile(90))
+		res += fmt.Sprintf("50%%: %.2fms\n\n", s.interEventHistPrecise.Percentile(50))
+
+		res += "Inter-event latency distribution in ms:\n"
+		res += s.interEventHist.String() + "\n"
+	}
+
+	res += "Events per stream distribution:\n"
+	res += s.eventsHist.String() + "\n"
+
+	res += "Stream duration distribution in ms:\n"
+	res += s.durationHist.String() + "\n"
+
+	return res
+}
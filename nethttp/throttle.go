@@ -0,0 +1,141 @@
+package nethttp
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Throughput accumulates bytes and time spent on transfers of throttled connections.
+type Throughput struct {
+	bytes   int64
+	elapsed int64
+}
+
+// Add registers transfer.
+func (t *Throughput) Add(n int, elapsed time.Duration) {
+	atomic.AddInt64(&t.bytes, int64(n))
+	atomic.AddInt64(&t.elapsed, int64(elapsed))
+}
+
+// BytesPerSecond returns average effective throughput of a connection.
+func (t *Throughput) BytesPerSecond() float64 {
+	elapsed := time.Duration(atomic.LoadInt64(&t.elapsed))
+	if elapsed == 0 {
+		return 0
+	}
+
+	return float64(atomic.LoadInt64(&t.bytes)) / elapsed.Seconds()
+}
+
+// throttledConn limits read and write speed of a connection.
+type throttledConn struct {
+	net.Conn
+
+	rl *rate.Limiter
+	wl *rate.Limiter
+	t  *Throughput
+
+	wrote int32 // Write happened since last read, next read waits for response.
+}
+
+// ThrottleConn wraps connection to limit its read and write speed to bytesPerSec in each direction.
+//
+// Effective throughput is collected into t if it is not nil.
+func ThrottleConn(c net.Conn, bytesPerSec int64, t *Throughput) net.Conn {
+	burst := int(bytesPerSec / 10)
+	if burst < 1 {
+		burst = 1
+	}
+
+	if burst > 64*1024 {
+		burst = 64 * 1024
+	}
+
+	tc := &throttledConn{
+		Conn: c,
+		rl:   rate.NewLimiter(rate.Limit(bytesPerSec), burst),
+		wl:   rate.NewLimiter(rate.Limit(bytesPerSec), burst),
+		t:    t,
+	}
+
+	// Drain initial burst to avoid exceeding the rate on short transfers.
+	now := time.Now()
+	tc.rl.AllowN(now, burst)
+	tc.wl.AllowN(now, burst)
+
+	return tc
+}
+
+// Read reads data from the connection.
+// Read can be made to time out and return an Error with Timeout() == true
+// after a fixed time limit; see SetDeadline and SetReadDeadline.
+func (c *throttledConn) Read(b []byte) (n int, err error) {
+	start := time.Now()
+
+	if len(b) > c.rl.Burst() {
+		b = b[:c.rl.Burst()]
+	}
+
+	n, err = c.Conn.Read(b)
+	if n > 0 {
+		// Waiting for response after request (TTFB or idle keep-alive) is not a transfer time,
+		// burst accumulated meanwhile is drained to keep the rate of response transfer.
+		if atomic.SwapInt32(&c.wrote, 0) == 1 {
+			start = time.Now()
+
+			if tokens := int(c.rl.TokensAt(start)); tokens > 0 {
+				c.rl.AllowN(start, tokens)
+			}
+		}
+
+		// Delay next read to keep the rate.
+		_ = c.rl.WaitN(context.Background(), n)
+
+		if c.t != nil {
+			c.t.Add(n, time.Since(start))
+		}
+	}
+
+	return n, err
+}
+
+// Write writes data to the connection.
+// Write can be made to time out and return an Error with Timeout() == true
+// after a fixed time limit; see SetDeadline and SetWriteDeadline.
+func (c *throttledConn) Write(b []byte) (n int, err error) {
+	start := time.Now()
+
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > c.wl.Burst() {
+			chunk = chunk[:c.wl.Burst()]
+		}
+
+		_ = c.wl.WaitN(context.Background(), len(chunk))
+
+		var w int
+
+		w, err = c.Conn.Write(chunk)
+		n += w
+
+		if err != nil {
+			break
+		}
+
+		b = b[w:]
+	}
+
+	if n > 0 {
+		atomic.StoreInt32(&c.wrote, 1)
+	}
+
+	if c.t != nil && n > 0 {
+		c.t.Add(n, time.Since(start))
+	}
+
+	return n, err
+}
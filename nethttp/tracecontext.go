@@ -0,0 +1,195 @@
+package nethttp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxTraceLog is a maximum number of logged trace IDs per kind.
+const maxTraceLog = 10
+
+// TraceContext generates W3C traceparent and X-Request-Id values from run ID and job index.
+//
+// Trace ID consists of 8 bytes derived from run ID and 8 bytes of job index,
+// so that all requests of a run can be found by trace ID prefix.
+type TraceContext struct {
+	runID       string
+	prefix      string
+	traceParent bool
+	requestID   bool
+	ratio       float64
+	slow        time.Duration
+
+	mu        sync.Mutex
+	slowCnt   int
+	failedCnt int
+	slowLog   []string
+	failedLog []string
+}
+
+// NewTraceContext creates trace context from TraceParent, RequestID and RunID flags,
+// it returns nil if neither traceparent nor X-Request-Id are enabled.
+//
+// Span export with OTLPEndpoint implies traceparent, OTLPSampleRatio controls sampled flag.
+func NewTraceContext(f Flags, slow time.Duration) (*TraceContext, error) {
+	if !f.TraceParent && !f.RequestID && f.OTLPEndpoint == "" {
+		return nil, nil //nolint:nilnil // Nil value disables injection.
+	}
+
+	t := &TraceContext{
+		runID:       f.RunID,
+		traceParent: f.TraceParent || f.OTLPEndpoint != "",
+		requestID:   f.RequestID,
+		ratio:       1,
+		slow:        slow,
+	}
+
+	// Ratio is validated by NewOTLPExporter.
+	if f.OTLPEndpoint != "" {
+		t.ratio = f.OTLPSampleRatio
+	}
+
+	if t.runID == "" {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("failed to generate run ID: %w", err)
+		}
+
+		t.runID = hex.EncodeToString(b)
+		t.prefix = t.runID
+	} else {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(t.runID))
+		t.prefix = hex.EncodeToString(h.Sum(nil))
+	}
+
+	return t, nil
+}
+
+// RunID returns run ID.
+func (t *TraceContext) RunID() string {
+	return t.runID
+}
+
+// TraceID returns trace ID of a job.
+func (t *TraceContext) TraceID(i int) string {
+	return t.prefix + fmt.Sprintf("%016x", uint64(i)) //nolint:gosec // Job index is not negative.
+}
+
+// SpanID returns ID of client span of a job.
+func (t *TraceContext) SpanID(i int) string {
+	// Span ID must not be zero.
+	return fmt.Sprintf("%016x", uint64(i)+1) //nolint:gosec // Job index is not negative.
+}
+
+// Sampled tells if job is sampled for span export.
+func (t *TraceContext) Sampled(i int) bool {
+	if t.ratio >= 1 {
+		return true
+	}
+
+	// Fibonacci hashing spreads sampled jobs evenly.
+	h := uint64(i) * 0x9E3779B97F4A7C15 //nolint:gosec // Job index is not negative.
+
+	return float64(h)/math.MaxUint64 < t.ratio
+}
+
+// Headers calls set with traceparent and X-Request-Id values of a job.
+func (t *TraceContext) Headers(i int, set func(k, v string)) {
+	if t.traceParent {
+		flags := "-01"
+		if !t.Sampled(i) {
+			flags = "-00"
+		}
+
+		set("Traceparent", "00-"+t.TraceID(i)+"-"+t.SpanID(i)+flags)
+	}
+
+	if t.requestID {
+		set("X-Request-Id", t.runID+"-"+strconv.Itoa(i))
+	}
+}
+
+// id returns logged identifier of a job.
+func (t *TraceContext) id(i int) string {
+	if t.traceParent {
+		return t.TraceID(i)
+	}
+
+	return t.runID + "-" + strconv.Itoa(i)
+}
+
+// Done logs trace ID if request has failed or was slow.
+func (t *TraceContext) Done(i int, latency time.Duration, err error) {
+	if err == nil && latency < t.slow {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err != nil {
+		t.failedCnt++
+
+		if len(t.failedLog) < maxTraceLog {
+			t.failedLog = append(t.failedLog, fmt.Sprintf("#%d %s %s", i, t.id(i), err.Error()))
+		}
+
+		return
+	}
+
+	t.slowCnt++
+
+	if len(t.slowLog) < maxTraceLog {
+		t.slowLog = append(t.slowLog, fmt.Sprintf("#%d %s %s", i, t.id(i), latency.Round(time.Millisecond)))
+	}
+}
+
+// String renders run ID and logged trace IDs.
+func (t *TraceContext) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	res := fmt.Sprintln("Run ID:", t.runID)
+
+	if t.traceParent {
+		res += fmt.Sprintln("Trace ID prefix:", t.prefix)
+	}
+
+	res += "\n"
+
+	for _, l := range []struct {
+		title string
+		cnt   int
+		log   []string
+	}{
+		{"failed requests", t.failedCnt, t.failedLog},
+		{"requests with latency more than " + t.slow.String(), t.slowCnt, t.slowLog},
+	} {
+		if l.cnt == 0 {
+			continue
+		}
+
+		res += fmt.Sprintf("Trace IDs of %s (%d", l.title, l.cnt)
+
+		if l.cnt > len(l.log) {
+			res += fmt.Sprintf(", first %d", len(l.log))
+		}
+
+		res += "):\n"
+
+		for _, s := range l.log {
+			res += s + "\n"
+		}
+
+		res += "\n"
+	}
+
+	return res
+}
@@ -4,8 +4,11 @@ package main
 import (
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/vearutop/plt/curl"
+	"github.com/vearutop/plt/grpc"
 	"github.com/vearutop/plt/loadgen"
 	"github.com/vearutop/plt/s3"
+	"github.com/vearutop/plt/socket"
+	"github.com/vearutop/plt/ws"
 )
 
 func main() {
@@ -13,7 +16,10 @@ func main() {
 	lf.Register()
 
 	curl.AddCommand(&lf)
+	grpc.AddCommand(&lf)
 	s3.AddCommand(&lf)
+	socket.AddCommand(&lf)
+	ws.AddCommand(&lf)
 
 	kingpin.Parse()
 }
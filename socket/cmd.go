@@ -0,0 +1,101 @@
+// Package socket implements raw TCP and UDP load tester commands.
+package socket
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/vearutop/plt/loadgen"
+)
+
+// Flags describes TCP or UDP command parameters.
+type Flags struct {
+	Network string // "tcp" or "udp".
+	Address string
+
+	// Payload is sent with every request, it is a text/template with Index (request index) field if Template is set.
+	Payload  string
+	Template bool
+	Hex      bool
+
+	// ResponseLength is a number of bytes to read as a response.
+	ResponseLength int
+
+	// ResponseDelimiter ends a response.
+	ResponseDelimiter string
+
+	NoResponse      bool
+	KeepAlive       bool
+	ConnectTimeout  time.Duration
+	ResponseTimeout time.Duration
+}
+
+// AddCommand registers tcp and udp commands into CLI app.
+func AddCommand(lf *loadgen.Flags) {
+	addCommand(lf, "tcp", "Raw TCP requests")
+	addCommand(lf, "udp", "Raw UDP datagrams")
+}
+
+func addCommand(lf *loadgen.Flags, network, help string) {
+	var (
+		f           = Flags{Network: network}
+		payloadFile string
+	)
+
+	c := kingpin.Command(network, help)
+	c.Flag("payload", "Payload to send with every request.").Short('d').StringVar(&f.Payload)
+	c.Flag("payload-file", "Path to file with payload.").PlaceHolder("FILE").StringVar(&payloadFile)
+	c.Flag("template", "Render payload as template, {{.Index}} is replaced with request index.").
+		BoolVar(&f.Template)
+	c.Flag("hex", "Payload is hex encoded, e.g. for binary protocols.").BoolVar(&f.Hex)
+
+	c.Flag("response-length", "Number of bytes to read as a response.").PlaceHolder("BYTES").
+		IntVar(&f.ResponseLength)
+	c.Flag("response-delimiter", "Sequence that ends a response, Go escapes are allowed, e.g. '\\r\\n'.").
+		PlaceHolder("\\n").StringVar(&f.ResponseDelimiter)
+	c.Flag("no-response", "Do not wait for response, only send payload.").BoolVar(&f.NoResponse)
+	c.Flag("keep-alive", "Reuse connections between requests.").BoolVar(&f.KeepAlive)
+	c.Flag("connect-timeout", "Max time to establish connection.").Default("10s").DurationVar(&f.ConnectTimeout)
+	c.Flag("response-timeout", "Max time to send payload and receive response.").
+		Default("10s").DurationVar(&f.ResponseTimeout)
+
+	c.Arg("address", "Server address, e.g. localhost:6379.").Required().StringVar(&f.Address)
+
+	c.Action(func(_ *kingpin.ParseContext) error {
+		if payloadFile != "" {
+			b, err := os.ReadFile(payloadFile) //nolint:gosec // File is provided by user.
+			if err != nil {
+				return fmt.Errorf("failed to read payload file: %w", err)
+			}
+
+			f.Payload = string(b)
+		}
+
+		if f.ResponseDelimiter != "" {
+			d, err := strconv.Unquote(`"` + f.ResponseDelimiter + `"`)
+			if err != nil {
+				return fmt.Errorf("invalid response delimiter %q: %w", f.ResponseDelimiter, err)
+			}
+
+			f.ResponseDelimiter = d
+		}
+
+		return run(*lf, f)
+	})
+}
+
+func run(lf loadgen.Flags, f Flags) error {
+	lf.Prepare()
+
+	j, err := NewJobProducer(f)
+	if err != nil {
+		return err
+	}
+
+	defer j.Close()
+
+	return loadgen.Run(lf, j)
+}
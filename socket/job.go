@@ -0,0 +1,341 @@
+package socket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/vearutop/dynhist-go"
+	"github.com/vearutop/plt/report"
+)
+
+// maxDatagramSize is a size of read buffer enough for any UDP datagram.
+const maxDatagramSize = 64 * 1024
+
+// JobProducer sends payloads over TCP or UDP and waits for responses.
+type JobProducer struct {
+	f       Flags
+	payload []byte
+	tmpl    *template.Template
+	dialer  net.Dialer
+
+	connHist        *dynhist.Collector
+	respHist        *dynhist.Collector
+	respHistPrecise *dynhist.Collector
+
+	bytesRead    int64
+	bytesWritten int64
+
+	conns    int64
+	sent     int64
+	received int64
+
+	mu     sync.Mutex
+	idle   []*conn
+	sample []byte
+}
+
+// countingConn counts bytes of a connection.
+type countingConn struct {
+	net.Conn
+	j *JobProducer
+}
+
+// Read reads data from the connection.
+func (c countingConn) Read(b []byte) (n int, err error) {
+	n, err = c.Conn.Read(b)
+	atomic.AddInt64(&c.j.bytesRead, int64(n))
+
+	return n, err
+}
+
+// Write writes data to the connection.
+func (c countingConn) Write(b []byte) (n int, err error) {
+	n, err = c.Conn.Write(b)
+	atomic.AddInt64(&c.j.bytesWritten, int64(n))
+
+	return n, err
+}
+
+// conn is a connection with buffered reader.
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// NewJobProducer creates TCP or UDP load generator.
+func NewJobProducer(f Flags) (*JobProducer, error) {
+	if f.Network != "tcp" && f.Network != "udp" {
+		return nil, fmt.Errorf("unknown network %q, expected tcp or udp", f.Network)
+	}
+
+	if f.ConnectTimeout == 0 {
+		f.ConnectTimeout = 10 * time.Second
+	}
+
+	if f.ResponseTimeout == 0 {
+		f.ResponseTimeout = 10 * time.Second
+	}
+
+	// Without a rule the end of TCP response is the end of connection.
+	if f.Network == "tcp" && f.KeepAlive && !f.NoResponse && f.ResponseLength == 0 && f.ResponseDelimiter == "" {
+		return nil, errors.New("persistent TCP connection requires response length or delimiter")
+	}
+
+	j := &JobProducer{
+		f:      f,
+		dialer: net.Dialer{Timeout: f.ConnectTimeout},
+
+		connHist:        &dynhist.Collector{BucketsLimit: 10, WeightFunc: dynhist.LatencyWidth},
+		respHist:        &dynhist.Collector{BucketsLimit: 10, WeightFunc: dynhist.LatencyWidth},
+		respHistPrecise: &dynhist.Collector{BucketsLimit: 100, WeightFunc: dynhist.LatencyWidth},
+	}
+
+	if f.Template {
+		tmpl, err := template.New("payload").Parse(f.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse payload template: %w", err)
+		}
+
+		j.tmpl = tmpl
+	} else {
+		p, err := j.decode([]byte(f.Payload))
+		if err != nil {
+			return nil, err
+		}
+
+		j.payload = p
+	}
+
+	return j, nil
+}
+
+func (j *JobProducer) decode(p []byte) ([]byte, error) {
+	if !j.f.Hex {
+		return p, nil
+	}
+
+	d := make([]byte, hex.DecodedLen(len(bytes.TrimSpace(p))))
+	if _, err := hex.Decode(d, bytes.TrimSpace(p)); err != nil {
+		return nil, fmt.Errorf("failed to decode hex payload: %w", err)
+	}
+
+	return d, nil
+}
+
+// payloadFor returns payload of a request.
+func (j *JobProducer) payloadFor(i int) ([]byte, error) {
+	if j.tmpl == nil {
+		return j.payload, nil
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := j.tmpl.Execute(buf, struct{ Index int }{Index: i}); err != nil {
+		return nil, fmt.Errorf("failed to render payload: %w", err)
+	}
+
+	return j.decode(buf.Bytes())
+}
+
+// Close closes idle connections.
+func (j *JobProducer) Close() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, c := range j.idle {
+		_ = c.Close()
+	}
+
+	j.idle = nil
+}
+
+// acquire takes idle connection or dials a new one.
+func (j *JobProducer) acquire() (*conn, error) {
+	if j.f.KeepAlive {
+		j.mu.Lock()
+		if n := len(j.idle); n > 0 {
+			c := j.idle[n-1]
+			j.idle = j.idle[:n-1]
+			j.mu.Unlock()
+
+			return c, nil
+		}
+		j.mu.Unlock()
+	}
+
+	start := time.Now()
+
+	c, err := j.dialer.Dial(j.f.Network, j.f.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	j.connHist.Add(1000 * time.Since(start).Seconds())
+	atomic.AddInt64(&j.conns, 1)
+
+	cc := countingConn{Conn: c, j: j}
+
+	size := 4096
+	if j.f.Network == "udp" {
+		size = maxDatagramSize
+	}
+
+	return &conn{Conn: cc, r: bufio.NewReaderSize(cc, size)}, nil
+}
+
+// release returns healthy connection to idle pool.
+func (j *JobProducer) release(c *conn) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.idle = append(j.idle, c)
+}
+
+// read receives response by length or delimiter rule.
+func (j *JobProducer) read(c *conn) ([]byte, error) {
+	switch {
+	case j.f.ResponseLength > 0:
+		resp := make([]byte, j.f.ResponseLength)
+		_, err := io.ReadFull(c.r, resp)
+
+		return resp, err
+	case j.f.ResponseDelimiter != "":
+		delim := []byte(j.f.ResponseDelimiter)
+		last := delim[len(delim)-1]
+
+		var resp []byte
+
+		for {
+			b, err := c.r.ReadBytes(last)
+			resp = append(resp, b...)
+
+			if err != nil {
+				return resp, err
+			}
+
+			if bytes.HasSuffix(resp, delim) {
+				return resp, nil
+			}
+		}
+	case j.f.Network == "udp":
+		resp := make([]byte, maxDatagramSize)
+		n, err := c.r.Read(resp)
+
+		return resp[:n], err
+	default:
+		return io.ReadAll(c.r)
+	}
+}
+
+// RequestCounts returns numbers of sent payloads and received responses.
+func (j *JobProducer) RequestCounts() map[string]int {
+	return map[string]int{
+		"sent":     int(atomic.LoadInt64(&j.sent)),
+		"received": int(atomic.LoadInt64(&j.received)),
+	}
+}
+
+// Job sends payload and waits for response.
+func (j *JobProducer) Job(i int) (time.Duration, error) {
+	payload, err := j.payloadFor(i)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+
+	c, err := j.acquire()
+	if err != nil {
+		return 0, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	keep := false
+
+	defer func() {
+		if keep {
+			j.release(c)
+		} else {
+			_ = c.Close()
+		}
+	}()
+
+	if err := c.SetDeadline(time.Now().Add(j.f.ResponseTimeout)); err != nil {
+		return 0, fmt.Errorf("failed to set deadline: %w", err)
+	}
+
+	sent := time.Now()
+
+	if _, err := c.Write(payload); err != nil {
+		return 0, fmt.Errorf("failed to send payload: %w", err)
+	}
+
+	atomic.AddInt64(&j.sent, 1)
+
+	if !j.f.NoResponse {
+		resp, err := j.read(c)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read response: %w", err)
+		}
+
+		ms := 1000 * time.Since(sent).Seconds()
+		j.respHist.Add(ms)
+		j.respHistPrecise.Add(ms)
+		atomic.AddInt64(&j.received, 1)
+
+		j.mu.Lock()
+		if j.sample == nil {
+			j.sample = report.PeekBody(resp, 1000)
+		}
+		j.mu.Unlock()
+	}
+
+	keep = j.f.KeepAlive
+
+	return time.Since(start), nil
+}
+
+// String renders report.
+func (j *JobProducer) String() string {
+	conns := atomic.LoadInt64(&j.conns)
+	if conns == 0 {
+		return ""
+	}
+
+	res := fmt.Sprintln("Connections:", conns)
+	res += fmt.Sprintf("Payloads sent: %d, responses received: %d\n",
+		atomic.LoadInt64(&j.sent), atomic.LoadInt64(&j.received))
+	res += fmt.Sprintln("Bytes read", report.ByteSize(atomic.LoadInt64(&j.bytesRead)), "total, written",
+		report.ByteSize(atomic.LoadInt64(&j.bytesWritten)), "total") + "\n"
+
+	if j.connHist.Count > 0 {
+		res += "Connection latency distribution in ms:\n"
+		res += j.connHist.String() + "\n"
+	}
+
+	if j.respHist.Count > 0 {
+		res += "Response latency percentiles:\n"
+		res += fmt.Sprintf("99%%: %.2fms\n", j.respHistPrecise.Percentile(99))
+		res += fmt.Sprintf("95%%: %.2fms\n", j.respHistPrecise.Percentile(95))
+		res += fmt.Sprintf("90%%: %.2fms\n", j.respHistPrecise.Percentile(90))
+		res += fmt.Sprintf("50%%: %.2fms\n\n", j.respHistPrecise.Percentile(50))
+
+		res += "Response latency distribution in ms:\n"
+		res += j.respHist.String() + "\n"
+	}
+
+	j.mu.Lock()
+	if j.sample != nil {
+		res += "Response sample:\n" + string(j.sample) + "\n\n"
+	}
+	j.mu.Unlock()
+
+	return res
+}
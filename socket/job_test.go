@@ -0,0 +1,216 @@
+package socket_test
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vearutop/plt/loadgen"
+	"github.com/vearutop/plt/socket"
+)
+
+// lineServer replies with upper-cased lines, closing connection after first line if once is set.
+func lineServer(t *testing.T, once bool) (string, *int64) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+
+	var accepted int64
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			atomic.AddInt64(&accepted, 1)
+
+			go func() {
+				defer func() {
+					_ = c.Close()
+				}()
+
+				r := bufio.NewReader(c)
+
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+
+					if _, err := c.Write([]byte(strings.ToUpper(line))); err != nil || once {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	return l.Addr().String(), &accepted
+}
+
+func TestNewJobProducer_tcp(t *testing.T) {
+	addr, accepted := lineServer(t, false)
+	out := bytes.NewBuffer(nil)
+
+	lf := loadgen.Flags{
+		Number:       10,
+		Concurrency:  2,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       out,
+	}
+	f := socket.Flags{
+		Network:           "tcp",
+		Address:           addr,
+		Payload:           "ping {{.Index}}\n",
+		Template:          true,
+		ResponseDelimiter: "\n",
+	}
+
+	j, err := socket.NewJobProducer(f)
+	require.NoError(t, err)
+
+	defer j.Close()
+
+	require.NoError(t, loadgen.Run(lf, j))
+
+	res := out.String()
+	assert.Contains(t, res, "Connections: 10\n")
+	assert.Contains(t, res, "Payloads sent: 10, responses received: 10\n")
+	assert.Contains(t, res, "Connection latency distribution in ms:\n")
+	assert.Regexp(t, `Response latency distribution in ms:\n.+ \(10 events\)\n`, res)
+	assert.Regexp(t, `Response sample:\nPING \d\n`, res)
+	assert.Equal(t, map[string]int{"sent": 10, "received": 10}, j.RequestCounts())
+	assert.Equal(t, int64(10), atomic.LoadInt64(accepted))
+}
+
+func TestNewJobProducer_keepAlive(t *testing.T) {
+	addr, accepted := lineServer(t, false)
+	out := bytes.NewBuffer(nil)
+
+	lf := loadgen.Flags{
+		Number:       20,
+		Concurrency:  2,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       out,
+	}
+	f := socket.Flags{
+		Network:        "tcp",
+		Address:        addr,
+		Payload:        "70696e670a", // "ping\n".
+		Hex:            true,
+		ResponseLength: 5,
+		KeepAlive:      true,
+	}
+
+	j, err := socket.NewJobProducer(f)
+	require.NoError(t, err)
+
+	defer j.Close()
+
+	require.NoError(t, loadgen.Run(lf, j))
+
+	res := out.String()
+	assert.Contains(t, res, "Payloads sent: 20, responses received: 20\n")
+	assert.Contains(t, res, "Response sample:\nPING\n")
+	assert.LessOrEqual(t, atomic.LoadInt64(accepted), int64(2))
+
+	f.ResponseLength = 0
+	_, err = socket.NewJobProducer(f)
+	require.EqualError(t, err, "persistent TCP connection requires response length or delimiter")
+}
+
+func TestNewJobProducer_tcpClose(t *testing.T) {
+	addr, _ := lineServer(t, true)
+
+	f := socket.Flags{
+		Network: "tcp",
+		Address: addr,
+		Payload: "hello\n",
+	}
+
+	j, err := socket.NewJobProducer(f)
+	require.NoError(t, err)
+
+	defer j.Close()
+
+	_, err = j.Job(0)
+	require.NoError(t, err)
+	assert.Contains(t, j.String(), "Response sample:\nHELLO\n")
+
+	// Server closes connection before delimiter is received.
+	f.ResponseDelimiter = "\r\n"
+	j, err = socket.NewJobProducer(f)
+	require.NoError(t, err)
+
+	defer j.Close()
+
+	_, err = j.Job(0)
+	require.Error(t, err)
+	assert.Equal(t, map[string]int{"sent": 1, "received": 0}, j.RequestCounts())
+}
+
+func TestNewJobProducer_udp(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	defer func() {
+		_ = pc.Close()
+	}()
+
+	go func() {
+		buf := make([]byte, 1024)
+
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			_, _ = pc.WriteTo(bytes.ToUpper(buf[:n]), addr)
+		}
+	}()
+
+	out := bytes.NewBuffer(nil)
+
+	lf := loadgen.Flags{
+		Number:       10,
+		Concurrency:  2,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       out,
+	}
+	f := socket.Flags{
+		Network:         "udp",
+		Address:         pc.LocalAddr().String(),
+		Payload:         "ping",
+		KeepAlive:       true,
+		ResponseTimeout: time.Second,
+	}
+
+	j, err := socket.NewJobProducer(f)
+	require.NoError(t, err)
+
+	defer j.Close()
+
+	require.NoError(t, loadgen.Run(lf, j))
+
+	res := out.String()
+	assert.Contains(t, res, "Payloads sent: 10, responses received: 10\n")
+	assert.Contains(t, res, "Response sample:\nPING\n")
+	assert.Contains(t, res, "Bytes read 40B total, written 40B total\n")
+}
@@ -0,0 +1,98 @@
+// Package ws implements WebSocket load tester command.
+package ws
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/vearutop/plt/loadgen"
+)
+
+// Flags describes WebSocket command parameters.
+type Flags struct {
+	URL       string
+	HeaderMap map[string]string
+	Insecure  bool
+
+	// Message is a text/template of a message with Conn (job index), Seq (message index) and ID ("Conn-Seq") fields.
+	Message         string
+	Binary          bool
+	Messages        int
+	MessageInterval time.Duration
+
+	// MatchField is a top-level field of a JSON response to match with ID of a message,
+	// responses are matched to messages in order of sending if empty.
+	MatchField      string
+	NoResponse      bool
+	ResponseTimeout time.Duration
+	ConnectTimeout  time.Duration
+}
+
+// AddCommand registers ws command into CLI app.
+func AddCommand(lf *loadgen.Flags) {
+	var (
+		f           Flags
+		header      []string
+		messageFile string
+	)
+
+	ws := kingpin.Command("ws", "WebSocket messaging")
+	ws.Flag("header", "Pass custom header(s) to server, e.g. 'Origin: https://example.com'.").Short('H').
+		StringsVar(&header)
+	ws.Flag("insecure", "Allow insecure server connections when using wss.").Short('k').BoolVar(&f.Insecure)
+
+	ws.Flag("message", "Message template, {{.Conn}}, {{.Seq}} and {{.ID}} are replaced with connection index, "+
+		"message index and unique message ID.").Default("{{.ID}}").StringVar(&f.Message)
+	ws.Flag("message-file", "Path to file with message template.").PlaceHolder("FILE").StringVar(&messageFile)
+	ws.Flag("binary", "Send binary messages instead of text.").BoolVar(&f.Binary)
+	ws.Flag("messages", "Number of messages to send per connection.").Default("10").IntVar(&f.Messages)
+	ws.Flag("message-interval", "Pause between messages sent to a connection (not a rate), 0 sends without delay.").
+		PlaceHolder("100ms").DurationVar(&f.MessageInterval)
+
+	ws.Flag("match-field", "Top-level field of JSON response to match with message ID, "+
+		"responses are matched in order of sending if not set.").PlaceHolder("id").StringVar(&f.MatchField)
+	ws.Flag("no-response", "Do not wait for responses, only send messages.").BoolVar(&f.NoResponse)
+	ws.Flag("response-timeout", "Max time to wait for outstanding responses before closing connection.").
+		Default("10s").DurationVar(&f.ResponseTimeout)
+	ws.Flag("connect-timeout", "Max time for connection and upgrade.").Default("30s").DurationVar(&f.ConnectTimeout)
+
+	ws.Arg("url", "The URL, e.g. ws://localhost:8080/ws.").Required().StringVar(&f.URL)
+
+	ws.Action(func(_ *kingpin.ParseContext) error {
+		if messageFile != "" {
+			b, err := os.ReadFile(messageFile) //nolint:gosec // File is provided by user.
+			if err != nil {
+				return fmt.Errorf("failed to read message file: %w", err)
+			}
+
+			f.Message = string(b)
+		}
+
+		f.HeaderMap = make(map[string]string, len(header))
+
+		for _, h := range header {
+			name, value, ok := strings.Cut(h, ":")
+			if !ok {
+				return fmt.Errorf("invalid header %q", h)
+			}
+
+			f.HeaderMap[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+
+		return run(*lf, f)
+	})
+}
+
+func run(lf loadgen.Flags, f Flags) error {
+	lf.Prepare()
+
+	j, err := NewJobProducer(f)
+	if err != nil {
+		return err
+	}
+
+	return loadgen.Run(lf, j)
+}
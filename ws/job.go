@@ -0,0 +1,457 @@
+package ws
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/vearutop/dynhist-go"
+	"github.com/vearutop/plt/report"
+)
+
+// JobProducer runs WebSocket sessions, one connection per job.
+type JobProducer struct {
+	f      Flags
+	tmpl   *template.Template
+	header http.Header
+	dialer websocket.Dialer
+	start  time.Time
+
+	connHist       *dynhist.Collector
+	upgradeHist    *dynhist.Collector
+	rttHist        *dynhist.Collector
+	rttHistPrecise *dynhist.Collector
+
+	bytesRead    int64
+	bytesWritten int64
+
+	conns      int64
+	sent       int64
+	received   int64
+	unmatched  int64
+	unanswered int64
+
+	mu         sync.Mutex
+	closeCodes map[int]int
+}
+
+// countingConn counts bytes of WebSocket connection.
+type countingConn struct {
+	net.Conn
+	j *JobProducer
+}
+
+// Read reads data from the connection.
+func (c countingConn) Read(b []byte) (n int, err error) {
+	n, err = c.Conn.Read(b)
+	atomic.AddInt64(&c.j.bytesRead, int64(n))
+
+	return n, err
+}
+
+// Write writes data to the connection.
+func (c countingConn) Write(b []byte) (n int, err error) {
+	n, err = c.Conn.Write(b)
+	atomic.AddInt64(&c.j.bytesWritten, int64(n))
+
+	return n, err
+}
+
+// NewJobProducer creates WebSocket load generator.
+func NewJobProducer(f Flags) (*JobProducer, error) {
+	tmpl, err := template.New("message").Parse(f.Message)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse message template: %w", err)
+	}
+
+	if f.ConnectTimeout == 0 {
+		f.ConnectTimeout = 30 * time.Second
+	}
+
+	if f.ResponseTimeout == 0 {
+		f.ResponseTimeout = 10 * time.Second
+	}
+
+	j := &JobProducer{
+		f:      f,
+		tmpl:   tmpl,
+		header: make(http.Header, len(f.HeaderMap)),
+		start:  time.Now(),
+
+		connHist:       &dynhist.Collector{BucketsLimit: 10, WeightFunc: dynhist.LatencyWidth},
+		upgradeHist:    &dynhist.Collector{BucketsLimit: 10, WeightFunc: dynhist.LatencyWidth},
+		rttHist:        &dynhist.Collector{BucketsLimit: 10, WeightFunc: dynhist.LatencyWidth},
+		rttHistPrecise: &dynhist.Collector{BucketsLimit: 100, WeightFunc: dynhist.LatencyWidth},
+
+		closeCodes: make(map[int]int),
+	}
+
+	for k, v := range f.HeaderMap {
+		j.header.Set(k, v)
+	}
+
+	if f.Insecure {
+		j.dialer.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} //nolint:gosec // Explicitly requested.
+	}
+
+	netDialer := net.Dialer{Timeout: f.ConnectTimeout}
+
+	j.dialer.NetDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		start := time.Now()
+
+		conn, err := netDialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		j.connHist.Add(1000 * time.Since(start).Seconds())
+
+		return countingConn{Conn: conn, j: j}, nil
+	}
+
+	return j, nil
+}
+
+// RequestCounts returns numbers of sent and received messages.
+func (j *JobProducer) RequestCounts() map[string]int {
+	return map[string]int{
+		"sent":     int(atomic.LoadInt64(&j.sent)),
+		"received": int(atomic.LoadInt64(&j.received)),
+	}
+}
+
+// String renders report.
+func (j *JobProducer) String() string {
+	conns := atomic.LoadInt64(&j.conns)
+	if conns == 0 {
+		return ""
+	}
+
+	elapsed := time.Since(j.start).Seconds()
+	sent := atomic.LoadInt64(&j.sent)
+	received := atomic.LoadInt64(&j.received)
+
+	res := fmt.Sprintln("WebSocket connections:", conns)
+	res += fmt.Sprintf("Messages sent: %d (%.2f/s), received: %d (%.2f/s), unmatched: %d, unanswered: %d\n",
+		sent, float64(sent)/elapsed, received, float64(received)/elapsed,
+		atomic.LoadInt64(&j.unmatched), atomic.LoadInt64(&j.unanswered))
+	res += fmt.Sprintln("Bytes read", report.ByteSize(atomic.LoadInt64(&j.bytesRead)), "total, written",
+		report.ByteSize(atomic.LoadInt64(&j.bytesWritten)), "total") + "\n"
+
+	j.mu.Lock()
+	codes := make([]int, 0, len(j.closeCodes))
+
+	for code := range j.closeCodes {
+		codes = append(codes, code)
+	}
+
+	sort.Ints(codes)
+
+	res += "Connections by close code\n"
+
+	for _, code := range codes {
+		res += fmt.Sprintf("[%d] %d\n", code, j.closeCodes[code])
+	}
+	j.mu.Unlock()
+
+	res += "\n"
+
+	if j.connHist.Count > 0 {
+		res += "Connection latency distribution in ms:\n"
+		res += j.connHist.String() + "\n"
+	}
+
+	if j.upgradeHist.Count > 0 {
+		res += "TLS handshake and upgrade latency distribution in ms:\n"
+		res += j.upgradeHist.String() + "\n"
+	}
+
+	if j.rttHist.Count > 0 {
+		res += "Message round trip latency percentiles:\n"
+		res += fmt.Sprintf("99%%: %.2fms\n", j.rttHistPrecise.Percentile(99))
+		res += fmt.Sprintf("95%%: %.2fms\n", j.rttHistPrecise.Percentile(95))
+		res += fmt.Sprintf("90%%: %.2fms\n", j.rttHistPrecise.Percentile(90))
+		res += fmt.Sprintf("50%%: %.2fms\n\n", j.rttHistPrecise.Percentile(50))
+
+		res += "Message round trip latency distribution in ms:\n"
+		res += j.rttHist.String() + "\n"
+	}
+
+	return res
+}
+
+// timeoutError marks sessions that did not receive responses in time.
+type timeoutError struct {
+	error
+}
+
+func (timeoutError) Timeout() bool {
+	return true
+}
+
+func (e timeoutError) Unwrap() error {
+	return e.error
+}
+
+// message describes template data.
+type message struct {
+	Conn int
+	Seq  int
+	ID   string
+}
+
+// session tracks messages of a connection that wait for responses.
+type session struct {
+	j    *JobProducer
+	conn *websocket.Conn
+
+	mu       sync.Mutex
+	fifo     []time.Time
+	byID     map[string]time.Time
+	matched  int
+	answered chan struct{}
+}
+
+// add registers sent message.
+func (s *session) add(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.byID != nil {
+		s.byID[id] = time.Now()
+	} else {
+		s.fifo = append(s.fifo, time.Now())
+	}
+}
+
+// pending returns number of messages without response.
+func (s *session) pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.byID != nil {
+		return len(s.byID)
+	}
+
+	return len(s.fifo)
+}
+
+// match finds sent message of a response.
+func (s *session) match(data []byte) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var sent time.Time
+
+	if s.byID != nil {
+		var resp map[string]any
+
+		if err := json.Unmarshal(data, &resp); err != nil {
+			return sent, false
+		}
+
+		v, ok := resp[s.j.f.MatchField]
+		if !ok {
+			return sent, false
+		}
+
+		id, ok := v.(string)
+		if !ok {
+			id = fmt.Sprint(v)
+		}
+
+		if sent, ok = s.byID[id]; !ok {
+			return sent, false
+		}
+
+		delete(s.byID, id)
+	} else {
+		if len(s.fifo) == 0 {
+			return sent, false
+		}
+
+		sent = s.fifo[0]
+		s.fifo = s.fifo[1:]
+	}
+
+	s.matched++
+
+	if s.matched == s.j.f.Messages {
+		close(s.answered)
+	}
+
+	return sent, true
+}
+
+// read receives messages until connection is closed.
+func (s *session) read() error {
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			var ce *websocket.CloseError
+
+			if errors.As(err, &ce) {
+				s.j.mu.Lock()
+				s.j.closeCodes[ce.Code]++
+				s.j.mu.Unlock()
+
+				return nil
+			}
+
+			return fmt.Errorf("failed to read message: %w", err)
+		}
+
+		atomic.AddInt64(&s.j.received, 1)
+
+		sent, ok := s.match(data)
+		if !ok {
+			atomic.AddInt64(&s.j.unmatched, 1)
+
+			continue
+		}
+
+		ms := 1000 * time.Since(sent).Seconds()
+		s.j.rttHist.Add(ms)
+		s.j.rttHistPrecise.Add(ms)
+	}
+}
+
+// Job runs a WebSocket session.
+func (j *JobProducer) Job(i int) (time.Duration, error) {
+	start := time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), j.f.ConnectTimeout)
+	defer cancel()
+
+	d := j.dialer
+	connDone := start
+
+	d.NetDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		c, err := j.dialer.NetDialContext(ctx, network, addr)
+		connDone = time.Now()
+
+		return c, err
+	}
+
+	conn, resp, err := d.DialContext(ctx, j.f.URL, j.header)
+	if err != nil {
+		if resp != nil {
+			return 0, fmt.Errorf("failed to connect: %w, status %s", err, resp.Status)
+		}
+
+		return 0, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	j.upgradeHist.Add(1000 * time.Since(connDone).Seconds())
+	atomic.AddInt64(&j.conns, 1)
+
+	s := session{j: j, conn: conn, answered: make(chan struct{})}
+	if j.f.MatchField != "" {
+		s.byID = make(map[string]time.Time, j.f.Messages)
+	}
+
+	readErr := make(chan error, 1)
+
+	go func() {
+		readErr <- s.read()
+	}()
+
+	msgType := websocket.TextMessage
+	if j.f.Binary {
+		msgType = websocket.BinaryMessage
+	}
+
+	buf := bytes.NewBuffer(nil)
+
+	for seq := range j.f.Messages {
+		if seq > 0 && j.f.MessageInterval > 0 {
+			time.Sleep(j.f.MessageInterval)
+		}
+
+		buf.Reset()
+
+		m := message{Conn: i, Seq: seq, ID: strconv.Itoa(i) + "-" + strconv.Itoa(seq)}
+		if err := j.tmpl.Execute(buf, m); err != nil {
+			return 0, fmt.Errorf("failed to render message: %w", err)
+		}
+
+		if !j.f.NoResponse {
+			s.add(m.ID)
+		}
+
+		if err := conn.WriteMessage(msgType, buf.Bytes()); err != nil {
+			return 0, fmt.Errorf("failed to send message: %w", err)
+		}
+
+		atomic.AddInt64(&j.sent, 1)
+	}
+
+	if !j.f.NoResponse && j.f.Messages > 0 {
+		select {
+		case <-s.answered:
+		case err := <-readErr:
+			// Connection was closed by server, reader is done.
+			n := s.pending()
+			atomic.AddInt64(&j.unanswered, int64(n))
+
+			if err != nil {
+				return 0, err
+			}
+
+			if n > 0 {
+				return 0, fmt.Errorf("connection closed by server with %d unanswered messages", n)
+			}
+
+			return time.Since(start), nil
+		case <-time.After(j.f.ResponseTimeout):
+			// Reader is stopped, so that late responses are not matched after unanswered messages are counted.
+			_ = conn.SetReadDeadline(time.Now())
+			<-readErr
+
+			n := s.pending()
+			atomic.AddInt64(&j.unanswered, int64(n))
+
+			_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+				time.Now().Add(time.Second))
+
+			if n > 0 {
+				return 0, timeoutError{fmt.Errorf("response timeout with %d unanswered messages", n)}
+			}
+
+			return time.Since(start), nil
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+
+	// Close may be already sent in reply to server.
+	err = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), deadline)
+	if err != nil && !errors.Is(err, websocket.ErrCloseSent) {
+		return 0, fmt.Errorf("failed to close connection: %w", err)
+	}
+
+	// Wait for server to confirm close.
+	_ = conn.SetReadDeadline(deadline)
+
+	if err := <-readErr; err != nil {
+		return 0, err
+	}
+
+	return time.Since(start), nil
+}
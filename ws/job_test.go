@@ -0,0 +1,251 @@
+package ws_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vearutop/plt/loadgen"
+	"github.com/vearutop/plt/ws"
+)
+
+func TestNewJobProducer(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "bar", r.Header.Get("X-Foo"))
+
+		conn, err := upgrader.Upgrade(rw, r, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		defer func() {
+			_ = conn.Close()
+		}()
+
+		var mu sync.Mutex
+
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			var req struct {
+				ID string `json:"id"`
+			}
+
+			assert.NoError(t, json.Unmarshal(data, &req))
+
+			// Responses are reordered to check matching by ID.
+			go func() {
+				if strings.HasSuffix(req.ID, "-0") {
+					time.Sleep(10 * time.Millisecond)
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+
+				_ = conn.WriteJSON(map[string]any{"id": req.ID, "ok": true})
+			}()
+		}
+	}))
+	defer srv.Close()
+
+	out := bytes.NewBuffer(nil)
+
+	lf := loadgen.Flags{
+		Number:       4,
+		Concurrency:  2,
+		Duration:     time.Minute,
+		SlowResponse: time.Second,
+		Output:       out,
+	}
+	f := ws.Flags{
+		URL:             "ws" + strings.TrimPrefix(srv.URL, "http"),
+		HeaderMap:       map[string]string{"X-Foo": "bar"},
+		Message:         `{"id":"{{.ID}}"}`,
+		Messages:        5,
+		MatchField:      "id",
+		ResponseTimeout: time.Second,
+		ConnectTimeout:  time.Second,
+	}
+
+	j, err := ws.NewJobProducer(f)
+	require.NoError(t, err)
+
+	require.NoError(t, loadgen.Run(lf, j))
+
+	res := out.String()
+	assert.Contains(t, res, "WebSocket connections: 4\n")
+	assert.Regexp(t, `Messages sent: 20 \([\d.]+/s\), received: 20 \([\d.]+/s\), unmatched: 0, unanswered: 0\n`, res)
+	assert.Contains(t, res, "Connections by close code\n[1000] 4\n")
+	assert.Contains(t, res, "Connection latency distribution in ms:\n")
+	assert.Contains(t, res, "TLS handshake and upgrade latency distribution in ms:\n")
+	assert.Regexp(t, `Message round trip latency distribution in ms:\n.+ \(20 events\)\n`, res)
+	assert.Equal(t, map[string]int{"sent": 20, "received": 20}, j.RequestCounts())
+}
+
+func TestNewJobProducer_closed(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(rw, r, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		defer func() {
+			_ = conn.Close()
+		}()
+
+		// Echo first message, then close with internal error.
+		mt, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		_ = conn.WriteMessage(mt, data)
+		_ = conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "oops"), time.Now().Add(time.Second))
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	f := ws.Flags{
+		URL:             "ws" + strings.TrimPrefix(srv.URL, "http"),
+		Message:         "{{.ID}}",
+		Messages:        1,
+		MessageInterval: 10 * time.Millisecond,
+		ResponseTimeout: time.Second,
+		ConnectTimeout:  time.Second,
+	}
+
+	j, err := ws.NewJobProducer(f)
+	require.NoError(t, err)
+
+	_, err = j.Job(0)
+	require.NoError(t, err)
+
+	f.Messages = 3
+	j, err = ws.NewJobProducer(f)
+	require.NoError(t, err)
+
+	_, err = j.Job(0)
+	require.Error(t, err)
+	assert.Contains(t, j.String(), "Connections by close code\n[1011] 1\n")
+}
+
+func TestNewJobProducer_closedUnanswered(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(rw, r, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		defer func() {
+			_ = conn.Close()
+		}()
+
+		// Receive messages, then close normally without responses.
+		for range 2 {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+
+		_ = conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	f := ws.Flags{
+		URL:             "ws" + strings.TrimPrefix(srv.URL, "http"),
+		Message:         "{{.ID}}",
+		Messages:        2,
+		ResponseTimeout: time.Second,
+		ConnectTimeout:  time.Second,
+	}
+
+	j, err := ws.NewJobProducer(f)
+	require.NoError(t, err)
+
+	_, err = j.Job(0)
+	require.EqualError(t, err, "connection closed by server with 2 unanswered messages")
+	assert.Contains(t, j.String(), ", unanswered: 2\n")
+	assert.Contains(t, j.String(), "Connections by close code\n[1000] 1\n")
+}
+
+func TestNewJobProducer_lateResponse(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(rw, r, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		defer func() {
+			_ = conn.Close()
+		}()
+
+		// Respond after client gave up waiting.
+		mt, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		time.Sleep(200 * time.Millisecond)
+
+		_ = conn.WriteMessage(mt, data)
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	f := ws.Flags{
+		URL:             "ws" + strings.TrimPrefix(srv.URL, "http"),
+		Message:         "{{.ID}}",
+		Messages:        1,
+		ResponseTimeout: 50 * time.Millisecond,
+		ConnectTimeout:  time.Second,
+	}
+
+	j, err := ws.NewJobProducer(f)
+	require.NoError(t, err)
+
+	_, err = j.Job(0)
+	require.EqualError(t, err, "response timeout with 1 unanswered messages")
+
+	var te interface{ Timeout() bool }
+
+	require.ErrorAs(t, err, &te)
+	assert.True(t, te.Timeout())
+	assert.Regexp(t, `received: 0 \([\d.]+/s\), unmatched: 0, unanswered: 1\n`, j.String())
+}